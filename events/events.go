@@ -80,6 +80,12 @@ func NewBaseEvent(eventType EventType, source string) BaseEvent {
 	}
 }
 
+// Base returns the BaseEvent itself, so that it is reachable through any
+// event that embeds it
+func (e *BaseEvent) Base() *BaseEvent {
+	return e
+}
+
 // WithCorrelation sets the correlation ID and returns the event
 func (e BaseEvent) WithCorrelation(correlationID string) BaseEvent {
 	e.CorrelationID = correlationID
@@ -3,6 +3,7 @@ package events
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
@@ -85,3 +86,35 @@ func TestEventKeys(t *testing.T) {
 		assert.Equal(t, "user-123", e.Key())
 	})
 }
+
+func TestTopicConfig_Validate(t *testing.T) {
+	cfg := DefaultTopicConfig()
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, RegulatoryRetention, cfg.Spec(cfg.AuditLog).Retention)
+	assert.Equal(t, RegulatoryRetention, cfg.Spec(cfg.SARFiling).Retention)
+	assert.Equal(t, cfg.Default, cfg.Spec(cfg.TransactionInitiated))
+	assert.Len(t, cfg.Names(), 13)
+
+	// Renaming the audit topic without a spec falls back to the default retention
+	renamed := DefaultTopicConfig()
+	renamed.AuditLog = "banking.audit.log.v2"
+	assert.ErrorContains(t, renamed.Validate(), "banking.audit.log.v2 must retain records")
+
+	short := DefaultTopicConfig()
+	short.Specs[short.SARFiling] = TopicSpec{Partitions: 3, ReplicationFactor: 3, MinInSyncReplicas: 2, Retention: 24 * time.Hour}
+	assert.Error(t, short.Validate())
+
+	forever := DefaultTopicConfig()
+	forever.Specs[forever.SARFiling] = TopicSpec{Partitions: 3, ReplicationFactor: 3, MinInSyncReplicas: 2, Retention: -1}
+	assert.NoError(t, forever.Validate())
+
+	compacted := DefaultTopicConfig()
+	spec := compacted.Spec(compacted.AuditLog)
+	spec.Compacted = true
+	compacted.Specs[compacted.AuditLog] = spec
+	assert.ErrorContains(t, compacted.Validate(), "must not be compacted")
+
+	badISR := DefaultTopicConfig()
+	badISR.Default.MinInSyncReplicas = 4
+	assert.ErrorContains(t, badISR.Validate(), "min in-sync replicas")
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnrouted is returned for event types without a route
+var ErrUnrouted = errors.New("event type has no route")
+
+// Routes maps each event type to the topics it is published to. The first
+// topic is the event type's primary topic; any others receive a copy.
+type Routes map[EventType][]TopicKey
+
+// AllEventTypes returns every event type declared in this package
+func AllEventTypes() []EventType {
+	return []EventType{
+		EventTypeTransactionInitiated,
+		EventTypeTransactionAnalyzing,
+		EventTypeTransactionApproved,
+		EventTypeTransactionRejected,
+		EventTypeTransactionCompleted,
+		EventTypeTransactionFailed,
+		EventTypeTransactionCancelled,
+		EventTypeTransactionWaitingReview,
+		EventTypeFraudAnalysisComplete,
+		EventTypeFraudSuspected,
+		EventTypeFraudReviewComplete,
+		EventTypeManualReviewRequired,
+		EventTypeBlocklistMatch,
+		EventTypeUserCreated,
+		EventTypeUserUpdated,
+		EventTypeUserLocked,
+		EventTypeUserPasswordChanged,
+		EventTypeLoginSuccess,
+		EventTypeLoginFailed,
+		EventTypeMFAEnabled,
+		EventTypeTokenRevoked,
+		EventTypeJWTKeyRotated,
+		EventTypeSecurityAlert,
+		EventTypeNotificationSent,
+		EventTypeNotificationFailed,
+		EventTypeAMLScreeningComplete,
+		EventTypeSARFiled,
+		EventTypeRiskProfileUpdated,
+		EventTypeAuditLogCreated,
+	}
+}
+
+// DefaultRoutes returns the routing of every event type in AllEventTypes.
+// Security events are also copied to the audit log.
+func DefaultRoutes() Routes {
+	return Routes{
+		// Transaction lifecycle; failures and cancellations go with rejections and
+		// keep their audit copy, and the fraud stages follow the fraud topics
+		EventTypeTransactionInitiated:     {TopicTransactionInitiated},
+		EventTypeTransactionAnalyzing:     {TopicFraudAnalysis},
+		EventTypeTransactionApproved:      {TopicTransactionApproved},
+		EventTypeTransactionRejected:      {TopicTransactionRejected},
+		EventTypeTransactionCompleted:     {TopicTransactionCompleted},
+		EventTypeTransactionFailed:        {TopicTransactionRejected, TopicAuditLog},
+		EventTypeTransactionCancelled:     {TopicTransactionRejected, TopicAuditLog},
+		EventTypeTransactionWaitingReview: {TopicManualReview},
+
+		EventTypeFraudAnalysisComplete: {TopicFraudAnalysis},
+		EventTypeFraudReviewComplete:   {TopicFraudAnalysis},
+		EventTypeFraudSuspected:        {TopicFraudSuspected},
+		EventTypeBlocklistMatch:        {TopicFraudSuspected},
+		EventTypeManualReviewRequired:  {TopicManualReview},
+
+		EventTypeUserCreated:         {TopicUserEvents},
+		EventTypeUserUpdated:         {TopicUserEvents},
+		EventTypeUserLocked:          {TopicUserEvents, TopicSecurityEvents, TopicAuditLog},
+		EventTypeUserPasswordChanged: {TopicUserEvents, TopicSecurityEvents, TopicAuditLog},
+
+		EventTypeLoginSuccess:  {TopicSecurityEvents, TopicAuditLog},
+		EventTypeLoginFailed:   {TopicSecurityEvents, TopicAuditLog},
+		EventTypeMFAEnabled:    {TopicSecurityEvents, TopicAuditLog},
+		EventTypeTokenRevoked:  {TopicSecurityEvents, TopicAuditLog},
+		EventTypeJWTKeyRotated: {TopicSecurityEvents, TopicAuditLog},
+		EventTypeSecurityAlert: {TopicSecurityEvents, TopicAuditLog},
+
+		EventTypeNotificationSent:   {TopicNotifications},
+		EventTypeNotificationFailed: {TopicNotifications},
+
+		EventTypeAMLScreeningComplete: {TopicAMLScreening},
+		EventTypeRiskProfileUpdated:   {TopicAMLScreening},
+		EventTypeSARFiled:             {TopicSARFiling},
+
+		EventTypeAuditLogCreated: {TopicAuditLog},
+	}
+}
+
+// Topics returns the topic names eventType is published to under cfg,
+// primary topic first. It fails with ErrUnrouted for unknown event types.
+func (r Routes) Topics(eventType EventType, cfg TopicConfig) ([]string, error) {
+	keys, ok := r[eventType]
+	if !ok || len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnrouted, eventType)
+	}
+
+	topics := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		topic, err := cfg.Name(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route for %s: %w", eventType, err)
+		}
+		// Topics shared in cfg receive a single copy
+		if !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+	return topics, nil
+}
+
+// Validate checks that every event type in eventTypes is routed and that
+// every route names a topic of TopicConfig
+func (r Routes) Validate(eventTypes ...EventType) error {
+	var cfg TopicConfig
+	for eventType, keys := range r {
+		if len(keys) == 0 {
+			return fmt.Errorf("%w: %s", ErrUnrouted, eventType)
+		}
+		for _, key := range keys {
+			if _, err := cfg.Name(key); err != nil {
+				return fmt.Errorf("invalid route for %s: %w", eventType, err)
+			}
+		}
+	}
+	for _, eventType := range eventTypes {
+		if _, ok := r[eventType]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnrouted, eventType)
+		}
+	}
+	return nil
+}
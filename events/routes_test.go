@@ -0,0 +1,91 @@
+package events
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// declaredEventTypes parses events.go for every EventType constant
+func declaredEventTypes(t *testing.T) []EventType {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "events.go", nil, 0)
+	require.NoError(t, err)
+
+	var declared []EventType
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			if ident, ok := value.Type.(*ast.Ident); !ok || ident.Name != "EventType" {
+				continue
+			}
+			for _, v := range value.Values {
+				s, err := strconv.Unquote(v.(*ast.BasicLit).Value)
+				require.NoError(t, err)
+				declared = append(declared, EventType(s))
+			}
+		}
+	}
+	return declared
+}
+
+func TestAllEventTypes_ListsEveryDeclaredType(t *testing.T) {
+	declared := declaredEventTypes(t)
+	require.NotEmpty(t, declared)
+	assert.ElementsMatch(t, declared, AllEventTypes())
+}
+
+func TestDefaultRoutes_RoutesEveryEventType(t *testing.T) {
+	assert.NoError(t, DefaultRoutes().Validate(AllEventTypes()...))
+}
+
+func TestRoutes_Topics(t *testing.T) {
+	cfg := DefaultTopicConfig()
+	routes := DefaultRoutes()
+
+	topics, err := routes.Topics(EventTypeTransactionInitiated, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"banking.transactions.initiated"}, topics)
+
+	topics, err = routes.Topics(EventTypeLoginFailed, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"banking.security.events", "banking.audit.log"}, topics, "security events fan out to audit")
+
+	_, err = routes.Topics(EventType("TransferScheduled"), cfg)
+	assert.ErrorIs(t, err, ErrUnrouted)
+	assert.Equal(t, "banking.audit.log", EventType("TransferScheduled").Topic(cfg), "the deprecated Topic keeps its audit log fallback")
+
+	// Failures and cancellations are not completions and keep an audit copy
+	for _, eventType := range []EventType{EventTypeTransactionFailed, EventTypeTransactionCancelled} {
+		topics, err = routes.Topics(eventType, cfg)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"banking.transactions.rejected", "banking.audit.log"}, topics, eventType)
+	}
+
+	// Topics shared in the config receive a single copy
+	cfg.AuditLog = cfg.SecurityEvents
+	topics, err = routes.Topics(EventTypeLoginFailed, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"banking.security.events"}, topics)
+}
+
+func TestRoutes_Validate(t *testing.T) {
+	routes := Routes{EventTypeUserCreated: {TopicUserEvents}}
+	assert.NoError(t, routes.Validate(EventTypeUserCreated))
+	assert.ErrorIs(t, routes.Validate(EventTypeUserUpdated), ErrUnrouted)
+
+	routes[EventTypeUserUpdated] = []TopicKey{"user_updates"}
+	assert.ErrorContains(t, routes.Validate(), `unknown topic key "user_updates"`)
+
+	routes[EventTypeUserUpdated] = nil
+	assert.ErrorIs(t, routes.Validate(), ErrUnrouted)
+}
@@ -1,6 +1,46 @@
 // Package events provides topic configuration for Kafka messaging.
 package events
 
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// RegulatoryRetention is the minimum retention of the audit log and SAR
+// filing topics. Records must be kept for at least seven years.
+const RegulatoryRetention = 7 * 365 * 24 * time.Hour
+
+// TopicSpec describes the desired partitioning and settings of a topic
+type TopicSpec struct {
+	Partitions        int32
+	ReplicationFactor int16
+	// Retention is how long records are kept; negative keeps them forever
+	Retention         time.Duration
+	MinInSyncReplicas int
+	// Compacted keeps only the latest record per key instead of deleting by age
+	Compacted bool
+}
+
+// Validate checks that the spec can be applied to a cluster
+func (s TopicSpec) Validate() error {
+	if s.Partitions < 1 {
+		return fmt.Errorf("partitions must be at least 1, got %d", s.Partitions)
+	}
+	if s.ReplicationFactor < 1 {
+		return fmt.Errorf("replication factor must be at least 1, got %d", s.ReplicationFactor)
+	}
+	if s.MinInSyncReplicas < 1 || s.MinInSyncReplicas > int(s.ReplicationFactor) {
+		return fmt.Errorf("min in-sync replicas must be between 1 and the replication factor %d, got %d",
+			s.ReplicationFactor, s.MinInSyncReplicas)
+	}
+	if s.Retention == 0 {
+		return errors.New("retention must be set; use a negative value to keep records forever")
+	}
+	return nil
+}
+
 // TopicConfig holds Kafka topic names for the banking platform
 type TopicConfig struct {
 	// Transaction topics
@@ -29,6 +69,72 @@ type TopicConfig struct {
 
 	// Audit topics
 	AuditLog string
+
+	// Default is the spec of topics without an entry in Specs
+	Default TopicSpec
+	// Specs overrides Default by topic name
+	Specs map[string]TopicSpec
+
+	// Shared lists topic names that intentionally carry more than one
+	// family of events; any other topic shared across families is rejected
+	Shared []string
+}
+
+// TopicKey names a topic field of TopicConfig. Keys are the snake_case field
+// names used in topic config files and, upper-cased, in environment variables.
+type TopicKey string
+
+// Topic keys of TopicConfig
+const (
+	TopicTransactionInitiated TopicKey = "transaction_initiated"
+	TopicTransactionApproved  TopicKey = "transaction_approved"
+	TopicTransactionRejected  TopicKey = "transaction_rejected"
+	TopicTransactionCompleted TopicKey = "transaction_completed"
+	TopicFraudAnalysis        TopicKey = "fraud_analysis"
+	TopicFraudSuspected       TopicKey = "fraud_suspected"
+	TopicManualReview         TopicKey = "manual_review"
+	TopicUserEvents           TopicKey = "user_events"
+	TopicSecurityEvents       TopicKey = "security_events"
+	TopicNotifications        TopicKey = "notifications"
+	TopicAMLScreening         TopicKey = "aml_screening"
+	TopicSARFiling            TopicKey = "sar_filing"
+	TopicAuditLog             TopicKey = "audit_log"
+)
+
+// topicField is a topic name field of TopicConfig
+type topicField struct {
+	key    TopicKey
+	family string
+	name   *string
+}
+
+// fields returns the topic name fields of cfg in declaration order
+func (cfg *TopicConfig) fields() []topicField {
+	return []topicField{
+		{TopicTransactionInitiated, "transaction", &cfg.TransactionInitiated},
+		{TopicTransactionApproved, "transaction", &cfg.TransactionApproved},
+		{TopicTransactionRejected, "transaction", &cfg.TransactionRejected},
+		{TopicTransactionCompleted, "transaction", &cfg.TransactionCompleted},
+		{TopicFraudAnalysis, "fraud", &cfg.FraudAnalysis},
+		{TopicFraudSuspected, "fraud", &cfg.FraudSuspected},
+		{TopicManualReview, "fraud", &cfg.ManualReview},
+		{TopicUserEvents, "user", &cfg.UserEvents},
+		{TopicSecurityEvents, "security", &cfg.SecurityEvents},
+		{TopicNotifications, "notification", &cfg.Notifications},
+		{TopicAMLScreening, "aml", &cfg.AMLScreening},
+		{TopicSARFiling, "aml", &cfg.SARFiling},
+		{TopicAuditLog, "audit", &cfg.AuditLog},
+	}
+}
+
+// Name returns the topic name of key
+func (cfg TopicConfig) Name(key TopicKey) (string, error) {
+	for _, f := range cfg.fields() {
+		if f.key == key {
+			return *f.name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown topic key %q", key)
 }
 
 // DefaultTopicConfig returns the default topic configuration
@@ -53,39 +159,102 @@ func DefaultTopicConfig() TopicConfig {
 		SARFiling:    "banking.aml.sar-filing",
 
 		AuditLog: "banking.audit.log",
+
+		Default: TopicSpec{
+			Partitions:        6,
+			ReplicationFactor: 3,
+			Retention:         7 * 24 * time.Hour,
+			MinInSyncReplicas: 2,
+		},
+		Specs: map[string]TopicSpec{
+			"banking.aml.sar-filing": {
+				Partitions:        3,
+				ReplicationFactor: 3,
+				Retention:         RegulatoryRetention,
+				MinInSyncReplicas: 2,
+			},
+			"banking.audit.log": {
+				Partitions:        6,
+				ReplicationFactor: 3,
+				Retention:         RegulatoryRetention,
+				MinInSyncReplicas: 2,
+			},
+		},
+	}
+}
+
+// Names returns every configured topic name once, in field order
+func (cfg TopicConfig) Names() []string {
+	fields := cfg.fields()
+	names := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if !seen[*f.name] {
+			seen[*f.name] = true
+			names = append(names, *f.name)
+		}
+	}
+	return names
+}
+
+// Spec returns the desired spec of topic
+func (cfg TopicConfig) Spec(topic string) TopicSpec {
+	if spec, ok := cfg.Specs[topic]; ok {
+		return spec
 	}
+	return cfg.Default
 }
 
-// Topic returns the appropriate Kafka topic for an event type
+// Validate checks that every topic is named, that no topic is shared across
+// event families unless listed in Shared, every topic spec, and that the
+// audit log and SAR filing topics keep records for at least
+// RegulatoryRetention without compaction
+func (cfg TopicConfig) Validate() error {
+	families := make(map[string]string)
+	for _, f := range cfg.fields() {
+		topic := *f.name
+		if topic == "" {
+			return fmt.Errorf("topic %s is not set", f.key)
+		}
+		family, ok := families[topic]
+		if !ok {
+			families[topic] = f.family
+		} else if family != f.family && !slices.Contains(cfg.Shared, topic) {
+			return fmt.Errorf("topic %s is used by both %s and %s events; list it in Shared if intended", topic, family, f.family)
+		}
+	}
+	for _, topic := range cfg.Names() {
+		if err := cfg.Spec(topic).Validate(); err != nil {
+			return fmt.Errorf("invalid spec for topic %s: %w", topic, err)
+		}
+	}
+
+	for _, topic := range []string{cfg.AuditLog, cfg.SARFiling} {
+		spec := cfg.Spec(topic)
+		if spec.Retention > 0 && spec.Retention < RegulatoryRetention {
+			return fmt.Errorf("topic %s must retain records for at least %s, got %s", topic, RegulatoryRetention, spec.Retention)
+		}
+		if spec.Compacted {
+			return fmt.Errorf("topic %s must not be compacted", topic)
+		}
+	}
+	return nil
+}
+
+// Topic returns the primary topic of an event type under DefaultRoutes. Event
+// types without a route fall back to the audit log, as they always have.
+//
+// Deprecated: Use Topics, which reports unrouted event types with
+// ErrUnrouted and returns every topic an event type fans out to.
 func (e EventType) Topic(cfg TopicConfig) string {
-	switch e {
-	case EventTypeTransactionInitiated:
-		return cfg.TransactionInitiated
-	case EventTypeTransactionApproved:
-		return cfg.TransactionApproved
-	case EventTypeTransactionRejected:
-		return cfg.TransactionRejected
-	case EventTypeTransactionCompleted:
-		return cfg.TransactionCompleted
-	case EventTypeFraudAnalysisComplete, EventTypeFraudReviewComplete:
-		return cfg.FraudAnalysis
-	case EventTypeFraudSuspected, EventTypeBlocklistMatch:
-		return cfg.FraudSuspected
-	case EventTypeManualReviewRequired:
-		return cfg.ManualReview
-	case EventTypeUserCreated, EventTypeUserUpdated, EventTypeUserLocked:
-		return cfg.UserEvents
-	case EventTypeLoginSuccess, EventTypeLoginFailed, EventTypeSecurityAlert, EventTypeJWTKeyRotated:
-		return cfg.SecurityEvents
-	case EventTypeNotificationSent, EventTypeNotificationFailed:
-		return cfg.Notifications
-	case EventTypeAMLScreeningComplete, EventTypeRiskProfileUpdated:
-		return cfg.AMLScreening
-	case EventTypeSARFiled:
-		return cfg.SARFiling
-	case EventTypeAuditLogCreated:
+	topics, err := e.Topics(cfg)
+	if err != nil {
 		return cfg.AuditLog
-	default:
-		return cfg.AuditLog // Default to audit log for unknown events
 	}
+	return topics[0]
+}
+
+// Topics returns every topic an event type is published to under DefaultRoutes
+func (e EventType) Topics(cfg TopicConfig) ([]string, error) {
+	return DefaultRoutes().Topics(e, cfg)
 }
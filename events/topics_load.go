@@ -0,0 +1,257 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// topicFile is the YAML and JSON layout read by LoadTopicConfig
+type topicFile struct {
+	Prefix  string                   `json:"prefix" yaml:"prefix"`
+	Topics  map[string]string        `json:"topics" yaml:"topics"`
+	Default *topicSpecFile           `json:"default" yaml:"default"`
+	Specs   map[string]topicSpecFile `json:"specs" yaml:"specs"`
+	Shared  []string                 `json:"shared" yaml:"shared"`
+}
+
+// topicSpecFile is a TopicSpec with retention written as a duration string
+type topicSpecFile struct {
+	Partitions        int32  `json:"partitions" yaml:"partitions"`
+	ReplicationFactor int16  `json:"replication_factor" yaml:"replication_factor"`
+	Retention         string `json:"retention" yaml:"retention"`
+	MinInSyncReplicas int    `json:"min_insync_replicas" yaml:"min_insync_replicas"`
+	Compacted         bool   `json:"compacted" yaml:"compacted"`
+}
+
+func (f topicSpecFile) spec() (TopicSpec, error) {
+	retention, err := parseRetention(f.Retention)
+	if err != nil {
+		return TopicSpec{}, err
+	}
+	return TopicSpec{
+		Partitions:        f.Partitions,
+		ReplicationFactor: f.ReplicationFactor,
+		Retention:         retention,
+		MinInSyncReplicas: f.MinInSyncReplicas,
+		Compacted:         f.Compacted,
+	}, nil
+}
+
+// parseRetention accepts a Go duration, a number of days such as "2555d",
+// or "-1" or "forever" to keep records forever
+func parseRetention(s string) (time.Duration, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case "-1", "forever":
+		return -1, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention %q: %w", s, err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention %q: %w", s, err)
+	}
+	return d, nil
+}
+
+// LoadTopicConfig loads a topic config from a YAML (.yaml, .yml) or JSON
+// (.json) file. Topics not named in the file keep their DefaultTopicConfig
+// names, and the file's prefix is applied to every name:
+//
+//	prefix: staging
+//	topics:
+//	  audit_log: banking.audit.log.v2
+//	default:
+//	  partitions: 6
+//	  replication_factor: 3
+//	  retention: 7d
+//	  min_insync_replicas: 2
+//	specs:
+//	  audit_log: {partitions: 6, replication_factor: 3, retention: forever, min_insync_replicas: 2}
+//
+// Topics and specs are keyed by the snake_case field name, e.g.
+// transaction_initiated. The result is validated.
+func LoadTopicConfig(path string) (TopicConfig, error) {
+	cfg := DefaultTopicConfig()
+	prefix, err := cfg.applyFile(path)
+	if err != nil {
+		return TopicConfig{}, err
+	}
+	cfg = cfg.WithPrefix(prefix)
+	if err := cfg.Validate(); err != nil {
+		return TopicConfig{}, err
+	}
+	return cfg, nil
+}
+
+// TopicConfigFromEnv loads a topic config from environment variables named
+// after prefix, e.g. KAFKA_TOPIC_AUDIT_LOG for prefix "KAFKA_TOPIC":
+//
+//	<prefix>_FILE      file read with LoadTopicConfig before the variables below
+//	<prefix>_<FIELD>   topic name, e.g. <prefix>_TRANSACTION_INITIATED
+//	<prefix>_PREFIX    namespace prefix, replacing the file's prefix
+//	<prefix>_SHARED    comma-separated topic names shared across event families
+//
+// Unset topics keep their DefaultTopicConfig names. The result is validated.
+func TopicConfigFromEnv(prefix string) (TopicConfig, error) {
+	env := func(name string) string {
+		return os.Getenv(prefix + "_" + name)
+	}
+
+	cfg := DefaultTopicConfig()
+	namespace := ""
+	if path := env("FILE"); path != "" {
+		var err error
+		if namespace, err = cfg.applyFile(path); err != nil {
+			return TopicConfig{}, err
+		}
+	}
+
+	for _, f := range cfg.fields() {
+		if name := env(strings.ToUpper(string(f.key))); name != "" {
+			cfg.rename(f, name)
+		}
+	}
+	if v := env("SHARED"); v != "" {
+		for _, topic := range strings.Split(v, ",") {
+			cfg.Shared = append(cfg.Shared, strings.TrimSpace(topic))
+		}
+	}
+	if v, ok := os.LookupEnv(prefix + "_PREFIX"); ok {
+		namespace = v
+	}
+
+	cfg = cfg.WithPrefix(namespace)
+	if err := cfg.Validate(); err != nil {
+		return TopicConfig{}, err
+	}
+	return cfg, nil
+}
+
+// applyFile merges the file at path into cfg and returns its prefix
+func (cfg *TopicConfig) applyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read topic config: %w", err)
+	}
+
+	var file topicFile
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&file)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&file)
+	default:
+		return "", fmt.Errorf("unsupported topic config format %q", ext)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to parse topic config %s: %w", path, err)
+	}
+
+	fields := make(map[string]topicField)
+	for _, f := range cfg.fields() {
+		fields[string(f.key)] = f
+	}
+
+	for key, name := range file.Topics {
+		f, ok := fields[key]
+		if !ok {
+			return "", fmt.Errorf("unknown topic %q in %s", key, path)
+		}
+		cfg.rename(f, name)
+	}
+
+	if file.Default != nil {
+		spec, err := file.Default.spec()
+		if err != nil {
+			return "", fmt.Errorf("invalid default spec in %s: %w", path, err)
+		}
+		cfg.Default = spec
+	}
+	for key, specFile := range file.Specs {
+		f, ok := fields[key]
+		if !ok {
+			return "", fmt.Errorf("unknown topic %q in specs of %s", key, path)
+		}
+		spec, err := specFile.spec()
+		if err != nil {
+			return "", fmt.Errorf("invalid spec for %s in %s: %w", key, path, err)
+		}
+		if cfg.Specs == nil {
+			cfg.Specs = make(map[string]TopicSpec)
+		}
+		cfg.Specs[*f.name] = spec
+	}
+
+	cfg.Shared = append(cfg.Shared, file.Shared...)
+	return file.Prefix, nil
+}
+
+// rename sets the topic name of f, moving its spec to the new name unless
+// another field still uses the old one
+func (cfg *TopicConfig) rename(f topicField, name string) {
+	old := *f.name
+	*f.name = name
+	if old == name {
+		return
+	}
+	spec, ok := cfg.Specs[old]
+	if !ok {
+		return
+	}
+	if _, taken := cfg.Specs[name]; !taken {
+		cfg.Specs[name] = spec
+	}
+	for _, other := range cfg.fields() {
+		if *other.name == old {
+			return
+		}
+	}
+	delete(cfg.Specs, old)
+}
+
+// WithPrefix returns a copy of cfg with every topic name, spec and shared
+// topic namespaced under prefix, e.g. "staging" turns
+// banking.transactions.initiated into staging.banking.transactions.initiated.
+// An empty prefix returns cfg unchanged.
+func (cfg TopicConfig) WithPrefix(prefix string) TopicConfig {
+	prefix = strings.TrimSuffix(prefix, ".")
+	if prefix == "" {
+		return cfg
+	}
+	namespaced := func(name string) string {
+		return prefix + "." + name
+	}
+
+	out := cfg
+	for _, f := range out.fields() {
+		*f.name = namespaced(*f.name)
+	}
+	out.Specs = make(map[string]TopicSpec, len(cfg.Specs))
+	for name, spec := range cfg.Specs {
+		out.Specs[namespaced(name)] = spec
+	}
+	out.Shared = make([]string, len(cfg.Shared))
+	for i, name := range cfg.Shared {
+		out.Shared[i] = namespaced(name)
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+package events
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestTopicConfig_WithPrefix(t *testing.T) {
+	cfg := DefaultTopicConfig().WithPrefix("staging.")
+
+	assert.Equal(t, "staging.banking.transactions.initiated", cfg.TransactionInitiated)
+	assert.Equal(t, "staging.banking.audit.log", cfg.AuditLog)
+	assert.Equal(t, RegulatoryRetention, cfg.Spec(cfg.AuditLog).Retention, "specs follow the prefixed names")
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, "banking.audit.log", DefaultTopicConfig().AuditLog)
+}
+
+func TestLoadTopicConfig_YAML(t *testing.T) {
+	path := writeFile(t, "topics.yaml", `
+prefix: tenant-a
+topics:
+  transaction_initiated: payments.initiated
+  audit_log: audit.v2
+default:
+  partitions: 12
+  replication_factor: 3
+  retention: 3d
+  min_insync_replicas: 2
+specs:
+  user_events: {partitions: 3, replication_factor: 3, retention: 720h, min_insync_replicas: 2, compacted: true}
+`)
+
+	cfg, err := LoadTopicConfig(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "tenant-a.payments.initiated", cfg.TransactionInitiated)
+	assert.Equal(t, "tenant-a.banking.transactions.approved", cfg.TransactionApproved)
+	assert.Equal(t, "tenant-a.audit.v2", cfg.AuditLog)
+	assert.Equal(t, int32(12), cfg.Spec(cfg.TransactionInitiated).Partitions)
+	assert.Equal(t, 72*time.Hour, cfg.Spec(cfg.TransactionInitiated).Retention)
+	assert.True(t, cfg.Spec(cfg.UserEvents).Compacted)
+	assert.Equal(t, RegulatoryRetention, cfg.Spec(cfg.AuditLog).Retention, "renamed audit topic keeps its regulatory spec")
+}
+
+func TestLoadTopicConfig_JSON(t *testing.T) {
+	path := writeFile(t, "topics.json", `{
+		"topics": {"sar_filing": "aml.sar"},
+		"specs": {"sar_filing": {"partitions": 1, "replication_factor": 3, "retention": "forever", "min_insync_replicas": 2}}
+	}`)
+
+	cfg, err := LoadTopicConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "aml.sar", cfg.SARFiling)
+	assert.Equal(t, time.Duration(-1), cfg.Spec("aml.sar").Retention)
+	_, stale := cfg.Specs["banking.aml.sar-filing"]
+	assert.False(t, stale)
+}
+
+func TestLoadTopicConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name, file, content, err string
+	}{
+		{"unknown topic", "t.yaml", "topics:\n  transfers: x\n", `unknown topic "transfers"`},
+		{"unknown field", "t.json", `{"topic": {}}`, "unknown field"},
+		{"format", "t.toml", "", "unsupported topic config format"},
+		{"retention", "t.yaml", "specs:\n  audit_log: {retention: 1y}\n", "invalid retention"},
+		{"empty name", "t.yaml", "topics:\n  notifications: \"\"\n", "topic notifications is not set"},
+		{"short audit retention", "t.yaml", "specs:\n  audit_log: {partitions: 1, replication_factor: 1, retention: 30d, min_insync_replicas: 1}\n", "must retain records"},
+		{"collision", "t.yaml", "topics:\n  notifications: banking.audit.log\n", "used by both notification and audit events"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTopicConfig(writeFile(t, tt.file, tt.content))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestTopicConfigFromEnv(t *testing.T) {
+	path := writeFile(t, "topics.yaml", "prefix: dev\ntopics:\n  notifications: notify\n")
+	t.Setenv("KAFKA_TOPIC_FILE", path)
+	t.Setenv("KAFKA_TOPIC_PREFIX", "staging")
+	t.Setenv("KAFKA_TOPIC_FRAUD_ANALYSIS", "fraud.all")
+	t.Setenv("KAFKA_TOPIC_FRAUD_SUSPECTED", "fraud.all")
+
+	cfg, err := TopicConfigFromEnv("KAFKA_TOPIC")
+	require.NoError(t, err)
+	assert.Equal(t, "staging.notify", cfg.Notifications, "file names are kept and the env prefix wins")
+	assert.Equal(t, "staging.fraud.all", cfg.FraudAnalysis)
+	assert.Equal(t, "staging.fraud.all", cfg.FraudSuspected, "topics may be shared within a family")
+	assert.Equal(t, "staging.banking.users.events", cfg.UserEvents)
+
+	// Sharing across families needs an explicit opt-in
+	t.Setenv("KAFKA_TOPIC_USER_EVENTS", "fraud.all")
+	_, err = TopicConfigFromEnv("KAFKA_TOPIC")
+	assert.ErrorContains(t, err, "used by both fraud and user events")
+
+	t.Setenv("KAFKA_TOPIC_SHARED", "fraud.all")
+	cfg, err = TopicConfigFromEnv("KAFKA_TOPIC")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"staging.fraud.all"}, cfg.Shared)
+}
+
+func TestTopicConfigFromEnv_Defaults(t *testing.T) {
+	cfg, err := TopicConfigFromEnv("KAFKA_TOPIC_UNSET")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTopicConfig(), cfg)
+}
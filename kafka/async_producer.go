@@ -0,0 +1,349 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// ErrProducerClosed is returned when publishing to a closed AsyncProducer
+var ErrProducerClosed = errors.New("kafka producer is closed")
+
+// PublishResult is the outcome of an asynchronous publish
+type PublishResult struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
+// PublishFuture resolves once the broker acknowledges or rejects a message
+type PublishFuture struct {
+	done   chan struct{}
+	result PublishResult
+}
+
+func newPublishFuture(topic string) *PublishFuture {
+	return &PublishFuture{
+		done:   make(chan struct{}),
+		result: PublishResult{Topic: topic},
+	}
+}
+
+func (f *PublishFuture) resolve(partition int32, offset int64, err error) {
+	f.result.Partition = partition
+	f.result.Offset = offset
+	f.result.Err = err
+	close(f.done)
+}
+
+// Done is closed once the result is available
+func (f *PublishFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result blocks until the publish completes and returns its outcome
+func (f *PublishFuture) Result() PublishResult {
+	<-f.done
+	return f.result
+}
+
+// Wait blocks until the publish completes or ctx is done and returns the publish error
+func (f *PublishFuture) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.result.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pendingMessage travels in ProducerMessage.Metadata until the broker responds
+type pendingMessage struct {
+	ctx    context.Context
+	future *PublishFuture
+	span   trace.Span
+	done   func(success bool)
+	start  time.Time
+}
+
+// AsyncProducer is a high-throughput Kafka producer with circuit breaker.
+// Publish returns immediately with a future; acknowledgements and errors are
+// drained by background goroutines.
+type AsyncProducer struct {
+	producer sarama.AsyncProducer
+	cb       *gobreaker.TwoStepCircuitBreaker
+	logger   *zap.Logger
+	tracer   trace.Tracer
+	metrics  *producerMetrics
+
+	mu       sync.RWMutex // guards closed so no send starts once Close has begun
+	closed   bool
+	stop     chan struct{}  // closed by Close to release blocked sends
+	sends    sync.WaitGroup // sends that may still write to the input channel
+	inflight sync.Mutex
+	pending  int
+	drained  chan struct{}
+	closing  bool                  // guarded by inflight
+	closeErr sarama.ProducerErrors // failures drained during Close, guarded by inflight
+	wg       sync.WaitGroup
+}
+
+// NewAsyncProducer creates a new asynchronous Kafka producer with circuit breaker
+func NewAsyncProducer(cfg ProducerConfig, logger *zap.Logger) (*AsyncProducer, error) {
+	if err := cfg.validateNonTransactional(); err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+
+	config, err := newSaramaProducerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewAsyncProducer(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka async producer: %w", err)
+	}
+
+	cb := gobreaker.NewTwoStepCircuitBreaker(newBreakerSettings("kafka-async-producer", cfg, logger))
+	metrics, err := newProducerMetrics(cfg.MeterProvider, "kafka-async-producer", cb.State)
+	if err != nil {
+		_ = producer.Close()
+		return nil, err
+	}
+
+	p := newAsyncProducer(producer, cb, logger)
+	p.metrics = metrics
+	return p, nil
+}
+
+// newAsyncProducer wraps a sarama async producer and starts draining its result channels
+func newAsyncProducer(producer sarama.AsyncProducer, cb *gobreaker.TwoStepCircuitBreaker, logger *zap.Logger) *AsyncProducer {
+	p := &AsyncProducer{
+		producer: producer,
+		cb:       cb,
+		logger:   logger,
+		tracer:   otel.Tracer("banking-shared/kafka"),
+		stop:     make(chan struct{}),
+	}
+
+	p.wg.Add(2)
+	go p.drainSuccesses()
+	go p.drainErrors()
+	return p
+}
+
+// Publish enqueues an event and returns a future for the broker's acknowledgement
+func (p *AsyncProducer) Publish(ctx context.Context, topic string, event Event) *PublishFuture {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		future := newPublishFuture(topic)
+		future.resolve(-1, -1, fmt.Errorf("failed to marshal event: %w", err))
+		return future
+	}
+
+	return p.PublishMessage(ctx, &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(event.Key()),
+		Value: sarama.ByteEncoder(payload),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("content-type"), Value: []byte("application/json")},
+		},
+	})
+}
+
+// PublishMessage enqueues a pre-built message and returns a future for the broker's acknowledgement
+func (p *AsyncProducer) PublishMessage(ctx context.Context, msg *sarama.ProducerMessage) *PublishFuture {
+	future := newPublishFuture(msg.Topic)
+	start := time.Now()
+
+	ctx, span := p.tracer.Start(ctx, "kafka.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(msg.Topic),
+		),
+	)
+
+	fail := func(err error) *PublishFuture {
+		p.metrics.recordPublish(ctx, msg.Topic, start, 0, 1, err)
+		span.RecordError(err)
+		span.End()
+		future.resolve(-1, -1, fmt.Errorf("failed to publish to %s: %w", msg.Topic, err))
+		return future
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+
+	done, err := p.cb.Allow()
+	if err != nil {
+		return fail(err)
+	}
+
+	propagator().Inject(ctx, producerCarrier{msg})
+	// Legacy header kept for consumers that predate W3C trace context propagation
+	msg.Headers = setHeader(msg.Headers, "trace-id", span.SpanContext().TraceID().String())
+	msg.Metadata = &pendingMessage{ctx: ctx, future: future, span: span, done: done, start: start}
+
+	// The lock only registers the send; holding it while blocked on the input
+	// channel would keep Close waiting
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		done(true) // not a broker failure
+		return fail(ErrProducerClosed)
+	}
+	p.sends.Add(1)
+	p.mu.RUnlock()
+	defer p.sends.Done()
+
+	p.addInflight()
+	select {
+	case p.producer.Input() <- msg:
+	case <-ctx.Done():
+		p.doneInflight()
+		done(true) // not a broker failure
+		return fail(ctx.Err())
+	case <-p.stop:
+		p.doneInflight()
+		done(true) // not a broker failure
+		return fail(ErrProducerClosed)
+	}
+
+	return future
+}
+
+func (p *AsyncProducer) drainSuccesses() {
+	defer p.wg.Done()
+	for msg := range p.producer.Successes() {
+		pm, ok := msg.Metadata.(*pendingMessage)
+		if !ok {
+			continue
+		}
+		pm.done(true)
+		p.metrics.recordPublish(pm.ctx, msg.Topic, pm.start, 1, 0, nil)
+		pm.span.SetAttributes(
+			semconv.MessagingKafkaDestinationPartition(int(msg.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+		)
+		pm.span.End()
+		pm.future.resolve(msg.Partition, msg.Offset, nil)
+		p.doneInflight()
+	}
+}
+
+func (p *AsyncProducer) drainErrors() {
+	defer p.wg.Done()
+	for perr := range p.producer.Errors() {
+		p.inflight.Lock()
+		if p.closing {
+			p.closeErr = append(p.closeErr, perr)
+		}
+		p.inflight.Unlock()
+
+		pm, ok := perr.Msg.Metadata.(*pendingMessage)
+		if !ok {
+			p.logger.Error("Failed to publish message", zap.String("topic", perr.Msg.Topic), zap.Error(perr.Err))
+			continue
+		}
+		pm.done(false)
+		p.metrics.recordPublish(pm.ctx, perr.Msg.Topic, pm.start, 0, 1, perr.Err)
+		pm.span.RecordError(perr.Err)
+		pm.span.End()
+		p.logger.Error("Failed to publish message",
+			zap.String("topic", perr.Msg.Topic),
+			zap.Error(perr.Err),
+		)
+		pm.future.resolve(-1, -1, fmt.Errorf("failed to publish to %s: %w", perr.Msg.Topic, perr.Err))
+		p.doneInflight()
+	}
+}
+
+func (p *AsyncProducer) addInflight() {
+	p.inflight.Lock()
+	defer p.inflight.Unlock()
+	if p.pending == 0 {
+		p.drained = make(chan struct{})
+	}
+	p.pending++
+}
+
+func (p *AsyncProducer) doneInflight() {
+	p.inflight.Lock()
+	defer p.inflight.Unlock()
+	p.pending--
+	if p.pending == 0 {
+		close(p.drained)
+	}
+}
+
+// Flush blocks until every message published so far has been acknowledged or
+// failed, or until ctx is done
+func (p *AsyncProducer) Flush(ctx context.Context) error {
+	p.inflight.Lock()
+	if p.pending == 0 {
+		p.inflight.Unlock()
+		return nil
+	}
+	drained := p.drained
+	p.inflight.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting messages, waits for in-flight messages to resolve and
+// closes the producer. Like sarama's Close, it returns the messages that failed
+// while it drained them as sarama.ProducerErrors; their futures fail as well.
+func (p *AsyncProducer) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	// Release blocked sends before closing the input channel under them
+	close(p.stop)
+	p.sends.Wait()
+
+	p.inflight.Lock()
+	p.closing = true
+	p.inflight.Unlock()
+
+	// The drain goroutines own Successes and Errors, so the producer is closed
+	// asynchronously and its errors are collected by drainErrors
+	p.producer.AsyncClose()
+	p.wg.Wait()
+	if err := p.metrics.unregister(); err != nil {
+		p.logger.Error("Failed to unregister producer metrics", zap.Error(err))
+	}
+
+	if len(p.closeErr) > 0 {
+		return fmt.Errorf("failed to close kafka async producer: %w", p.closeErr)
+	}
+	return nil
+}
+
+// IsHealthy returns true if the circuit breaker is closed
+func (p *AsyncProducer) IsHealthy() bool {
+	return p.cb.State() == gobreaker.StateClosed
+}
@@ -0,0 +1,155 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func newTestAsyncProducer(t *testing.T, settings gobreaker.Settings) (*AsyncProducer, *mocks.AsyncProducer) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	mockProducer := mocks.NewAsyncProducer(t, config)
+	return newAsyncProducer(mockProducer, gobreaker.NewTwoStepCircuitBreaker(settings), zaptest.NewLogger(t)), mockProducer
+}
+
+func TestAsyncProducer_Publish(t *testing.T) {
+	p, mockProducer := newTestAsyncProducer(t, gobreaker.Settings{Name: "test"})
+	ctx := context.Background()
+
+	mockProducer.ExpectInputAndSucceed()
+	mockProducer.ExpectInputAndFail(errors.New("kafka error"))
+
+	ok := p.Publish(ctx, "banking.audit.log", MockEvent{ID: "1"})
+	failed := p.Publish(ctx, "banking.audit.log", MockEvent{ID: "2"})
+
+	require.NoError(t, p.Flush(ctx))
+
+	select {
+	case <-ok.Done():
+	default:
+		t.Fatal("future must be resolved after Flush")
+	}
+	res := ok.Result()
+	assert.NoError(t, res.Err)
+	assert.Equal(t, "banking.audit.log", res.Topic)
+
+	err := failed.Wait(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "kafka error")
+
+	require.NoError(t, p.Close())
+}
+
+func TestAsyncProducer_CircuitBreakerOpen(t *testing.T) {
+	p, mockProducer := newTestAsyncProducer(t, gobreaker.Settings{
+		Name:        "test",
+		ReadyToTrip: func(counts gobreaker.Counts) bool { return counts.ConsecutiveFailures >= 1 },
+		Timeout:     time.Hour,
+	})
+	ctx := context.Background()
+
+	mockProducer.ExpectInputAndFail(errors.New("kafka error"))
+	assert.Error(t, p.Publish(ctx, "banking.audit.log", MockEvent{ID: "1"}).Wait(ctx))
+	assert.False(t, p.IsHealthy())
+
+	err := p.Publish(ctx, "banking.audit.log", MockEvent{ID: "2"}).Wait(ctx)
+	assert.ErrorIs(t, err, gobreaker.ErrOpenState)
+
+	require.NoError(t, p.Close())
+}
+
+func TestAsyncProducer_PublishAfterClose(t *testing.T) {
+	p, _ := newTestAsyncProducer(t, gobreaker.Settings{Name: "test"})
+	require.NoError(t, p.Close())
+
+	err := p.Publish(context.Background(), "banking.audit.log", MockEvent{ID: "1"}).Wait(context.Background())
+	assert.ErrorIs(t, err, ErrProducerClosed)
+	assert.NoError(t, p.Flush(context.Background()))
+}
+
+// closingAsyncProducer holds messages until AsyncClose and then fails them,
+// like sarama flushing to an unreachable broker on shutdown
+type closingAsyncProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func newClosingAsyncProducer() *closingAsyncProducer {
+	return &closingAsyncProducer{
+		input:     make(chan *sarama.ProducerMessage, 10),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+}
+
+func (f *closingAsyncProducer) Input() chan<- *sarama.ProducerMessage     { return f.input }
+func (f *closingAsyncProducer) Successes() <-chan *sarama.ProducerMessage { return f.successes }
+func (f *closingAsyncProducer) Errors() <-chan *sarama.ProducerError      { return f.errors }
+
+func (f *closingAsyncProducer) AsyncClose() {
+	close(f.input)
+	go func() {
+		for msg := range f.input {
+			f.errors <- &sarama.ProducerError{Msg: msg, Err: sarama.ErrOutOfBrokers}
+		}
+		close(f.successes)
+		close(f.errors)
+	}()
+}
+
+func TestAsyncProducer_CloseReturnsInFlightErrors(t *testing.T) {
+	p := newAsyncProducer(newClosingAsyncProducer(), gobreaker.NewTwoStepCircuitBreaker(gobreaker.Settings{Name: "test"}), zaptest.NewLogger(t))
+	ctx := context.Background()
+
+	first := p.Publish(ctx, "banking.audit.log", MockEvent{ID: "1"})
+	second := p.Publish(ctx, "banking.audit.log", MockEvent{ID: "2"})
+
+	err := p.Close()
+	require.Error(t, err)
+	var perrs sarama.ProducerErrors
+	require.ErrorAs(t, err, &perrs)
+	assert.Len(t, perrs, 2)
+	assert.ErrorIs(t, first.Wait(ctx), sarama.ErrOutOfBrokers)
+	assert.ErrorIs(t, second.Wait(ctx), sarama.ErrOutOfBrokers)
+
+	assert.NoError(t, p.Close(), "a second Close is a no-op")
+}
+
+func TestAsyncProducer_CloseReleasesBlockedPublish(t *testing.T) {
+	fake := newClosingAsyncProducer()
+	fake.input = make(chan *sarama.ProducerMessage) // nothing reads it, so sends block
+	p := newAsyncProducer(fake, gobreaker.NewTwoStepCircuitBreaker(gobreaker.Settings{Name: "test"}), zaptest.NewLogger(t))
+
+	published := make(chan *PublishFuture, 1)
+	go func() {
+		published <- p.Publish(context.Background(), "banking.audit.log", MockEvent{ID: "1"})
+	}()
+	require.Eventually(t, func() bool {
+		p.inflight.Lock()
+		defer p.inflight.Unlock()
+		return p.pending == 1
+	}, time.Second, 5*time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- p.Close() }()
+
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked behind a pending publish")
+	}
+	future := <-published
+	assert.ErrorIs(t, future.Wait(context.Background()), ErrProducerClosed)
+}
@@ -0,0 +1,296 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SASLMechanism is a SASL authentication mechanism supported by the brokers
+type SASLMechanism string
+
+// Supported SASL mechanisms
+const (
+	SASLPlain       SASLMechanism = sarama.SASLTypePlaintext
+	SASLScramSHA256 SASLMechanism = sarama.SASLTypeSCRAMSHA256
+	SASLScramSHA512 SASLMechanism = sarama.SASLTypeSCRAMSHA512
+	SASLOAuthBearer SASLMechanism = sarama.SASLTypeOAuth
+)
+
+// tokenTimeout bounds each call to a TokenProvider
+const tokenTimeout = 10 * time.Second
+
+// SecurityConfig holds the connection security settings shared by producers
+// and consumers. The zero value connects in plaintext without authentication.
+type SecurityConfig struct {
+	TLS  *TLSConfig
+	SASL *SASLConfig
+}
+
+// TLSConfig configures TLS for broker connections
+type TLSConfig struct {
+	// CAFile is a PEM CA bundle; empty uses the system roots
+	CAFile string
+	// CertFile and KeyFile hold a PEM client certificate for mutual TLS
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version; zero means TLS 1.2
+	MinVersion uint16
+	// ServerName overrides the host name used to verify broker certificates
+	ServerName string
+	// InsecureSkipVerify disables broker certificate verification (local development only)
+	InsecureSkipVerify bool
+}
+
+// SASLConfig configures SASL authentication
+type SASLConfig struct {
+	Mechanism SASLMechanism
+	// Username and Password are used by PLAIN and SCRAM
+	Username string
+	Password string
+	// TokenProvider supplies tokens for OAUTHBEARER
+	TokenProvider TokenProvider
+}
+
+// TokenProvider supplies OAuth bearer tokens for SASL/OAUTHBEARER. Token is
+// called whenever a broker connection authenticates, so implementations
+// should cache tokens until they are close to expiry.
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// FileTokenProvider reads the token from a file on every call, e.g. a token
+// that a sidecar or projected volume keeps refreshed
+type FileTokenProvider string
+
+// Token returns the trimmed contents of the file
+func (p FileTokenProvider) Token(context.Context) (string, error) {
+	token, err := os.ReadFile(string(p))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// Validate checks the configuration for missing or incompatible settings
+func (s SecurityConfig) Validate() error {
+	if s.TLS != nil {
+		if (s.TLS.CertFile == "") != (s.TLS.KeyFile == "") {
+			return errors.New("TLS client certificate requires both a cert file and a key file")
+		}
+		if s.TLS.MinVersion != 0 && s.TLS.MinVersion < tls.VersionTLS12 {
+			return errors.New("TLS minimum version must be at least TLS 1.2")
+		}
+	}
+
+	if s.SASL != nil {
+		switch s.SASL.Mechanism {
+		case SASLPlain, SASLScramSHA256, SASLScramSHA512:
+			if s.SASL.Username == "" || s.SASL.Password == "" {
+				return fmt.Errorf("SASL %s requires a username and password", s.SASL.Mechanism)
+			}
+		case SASLOAuthBearer:
+			if s.SASL.TokenProvider == nil {
+				return errors.New("SASL OAUTHBEARER requires a token provider")
+			}
+		default:
+			return fmt.Errorf("unsupported SASL mechanism %q", s.SASL.Mechanism)
+		}
+	}
+	return nil
+}
+
+// apply translates the security settings into a sarama config
+func (s SecurityConfig) apply(config *sarama.Config) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	if s.TLS != nil {
+		tlsConfig, err := s.TLS.Build()
+		if err != nil {
+			return err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if s.SASL != nil {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.Mechanism = sarama.SASLMechanism(s.SASL.Mechanism)
+		config.Net.SASL.User = s.SASL.Username
+		config.Net.SASL.Password = s.SASL.Password
+
+		switch s.SASL.Mechanism {
+		case SASLScramSHA256:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: scram.SHA256}
+			}
+		case SASLScramSHA512:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: scram.SHA512}
+			}
+		case SASLOAuthBearer:
+			config.Net.SASL.TokenProvider = saramaTokenProvider{s.SASL.TokenProvider}
+		}
+	}
+	return nil
+}
+
+// Build loads the certificates and returns the TLS client configuration
+func (c *TLSConfig) Build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.MinVersion != 0 {
+		tlsConfig.MinVersion = c.MinVersion
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// SecurityConfigFromEnv loads security settings from environment variables
+// named after prefix, e.g. KAFKA_SASL_MECHANISM for prefix "KAFKA":
+//
+//	<prefix>_TLS_ENABLED, <prefix>_TLS_CA_FILE, <prefix>_TLS_CERT_FILE,
+//	<prefix>_TLS_KEY_FILE, <prefix>_TLS_MIN_VERSION (1.2 or 1.3),
+//	<prefix>_TLS_SERVER_NAME, <prefix>_TLS_INSECURE_SKIP_VERIFY,
+//	<prefix>_SASL_MECHANISM, <prefix>_SASL_USERNAME, <prefix>_SASL_PASSWORD,
+//	<prefix>_SASL_PASSWORD_FILE, <prefix>_SASL_TOKEN_FILE
+//
+// TLS is enabled when TLS_ENABLED is true or any TLS file is set; SASL is
+// enabled when SASL_MECHANISM is set.
+func SecurityConfigFromEnv(prefix string) (SecurityConfig, error) {
+	env := func(name string) string {
+		return os.Getenv(prefix + "_" + name)
+	}
+
+	var cfg SecurityConfig
+
+	tlsEnabled := false
+	if v := env("TLS_ENABLED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return SecurityConfig{}, fmt.Errorf("invalid %s_TLS_ENABLED: %w", prefix, err)
+		}
+		tlsEnabled = b
+	}
+	if tlsEnabled || env("TLS_CA_FILE") != "" || env("TLS_CERT_FILE") != "" {
+		cfg.TLS = &TLSConfig{
+			CAFile:     env("TLS_CA_FILE"),
+			CertFile:   env("TLS_CERT_FILE"),
+			KeyFile:    env("TLS_KEY_FILE"),
+			ServerName: env("TLS_SERVER_NAME"),
+		}
+		switch v := env("TLS_MIN_VERSION"); v {
+		case "":
+		case "1.2":
+			cfg.TLS.MinVersion = tls.VersionTLS12
+		case "1.3":
+			cfg.TLS.MinVersion = tls.VersionTLS13
+		default:
+			return SecurityConfig{}, fmt.Errorf("invalid %s_TLS_MIN_VERSION %q", prefix, v)
+		}
+		if v := env("TLS_INSECURE_SKIP_VERIFY"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return SecurityConfig{}, fmt.Errorf("invalid %s_TLS_INSECURE_SKIP_VERIFY: %w", prefix, err)
+			}
+			cfg.TLS.InsecureSkipVerify = b
+		}
+	}
+
+	if mechanism := env("SASL_MECHANISM"); mechanism != "" {
+		cfg.SASL = &SASLConfig{
+			Mechanism: SASLMechanism(strings.ToUpper(mechanism)),
+			Username:  env("SASL_USERNAME"),
+			Password:  env("SASL_PASSWORD"),
+		}
+		if path := env("SASL_PASSWORD_FILE"); path != "" {
+			password, err := os.ReadFile(path)
+			if err != nil {
+				return SecurityConfig{}, fmt.Errorf("failed to read SASL password file: %w", err)
+			}
+			cfg.SASL.Password = strings.TrimSpace(string(password))
+		}
+		if path := env("SASL_TOKEN_FILE"); path != "" {
+			cfg.SASL.TokenProvider = FileTokenProvider(path)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return SecurityConfig{}, err
+	}
+	return cfg, nil
+}
+
+// scramClient implements sarama.SCRAMClient
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
+
+// saramaTokenProvider adapts a TokenProvider to sarama.AccessTokenProvider
+type saramaTokenProvider struct {
+	provider TokenProvider
+}
+
+func (p saramaTokenProvider) Token() (*sarama.AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenTimeout)
+	defer cancel()
+
+	token, err := p.provider.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth token: %w", err)
+	}
+	return &sarama.AccessToken{Token: token}, nil
+}
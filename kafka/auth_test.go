@@ -0,0 +1,266 @@
+package kafka
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xdg-go/scram"
+)
+
+// testPKI is a throwaway CA with a server and a client certificate, written as PEM files
+type testPKI struct {
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverCert tls.Certificate
+	pool       *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		return der, key
+	}
+
+	writePEM := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+		return path
+	}
+
+	serverDER, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	clientDER, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return &testPKI{
+		caFile:     writePEM("ca.pem", "CERTIFICATE", caDER),
+		certFile:   writePEM("client.pem", "CERTIFICATE", clientDER),
+		keyFile:    writePEM("client-key.pem", "EC PRIVATE KEY", clientKeyDER),
+		serverCert: tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey},
+		pool:       pool,
+	}
+}
+
+// startTLSListener accepts one connection requiring a client certificate
+// signed by the test CA and reports the handshake result
+func startTLSListener(t *testing.T, pki *testPKI) (string, <-chan error) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pki.serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool,
+		MinVersion:   tls.VersionTLS12,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	result := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			result <- err
+			return
+		}
+		defer conn.Close()
+		result <- conn.(*tls.Conn).Handshake()
+	}()
+	return ln.Addr().String(), result
+}
+
+func TestSecurityConfig_MutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	addr, serverResult := startTLSListener(t, pki)
+
+	cfg := DefaultProducerConfig([]string{addr}, "test-client")
+	cfg.Security.TLS = &TLSConfig{
+		CAFile:     pki.caFile,
+		CertFile:   pki.certFile,
+		KeyFile:    pki.keyFile,
+		MinVersion: tls.VersionTLS13,
+	}
+
+	config, err := newSaramaProducerConfig(cfg)
+	require.NoError(t, err)
+	require.NoError(t, config.Validate())
+	assert.True(t, config.Net.TLS.Enable)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.Net.TLS.Config.MinVersion)
+
+	conn, err := tls.Dial("tcp", addr, config.Net.TLS.Config)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, <-serverResult)
+	assert.Equal(t, uint16(tls.VersionTLS13), conn.ConnectionState().Version)
+}
+
+func TestSecurityConfig_UntrustedBroker(t *testing.T) {
+	pki := newTestPKI(t)
+	addr, _ := startTLSListener(t, pki)
+
+	// Client trusts a different CA than the one that issued the broker certificate
+	other := newTestPKI(t)
+	config, err := newSaramaConsumerConfig(ConsumerConfig{
+		Security: SecurityConfig{TLS: &TLSConfig{CAFile: other.caFile, CertFile: pki.certFile, KeyFile: pki.keyFile}},
+	})
+	require.NoError(t, err)
+
+	_, err = tls.Dial("tcp", addr, config.Net.TLS.Config)
+	require.Error(t, err)
+}
+
+func TestSecurityConfig_SCRAM(t *testing.T) {
+	for _, mechanism := range []SASLMechanism{SASLScramSHA256, SASLScramSHA512} {
+		t.Run(string(mechanism), func(t *testing.T) {
+			config, err := newSaramaConsumerConfig(ConsumerConfig{
+				Security: SecurityConfig{SASL: &SASLConfig{Mechanism: mechanism, Username: "svc", Password: "s3cret"}},
+			})
+			require.NoError(t, err)
+			require.NoError(t, config.Validate())
+			assert.True(t, config.Net.SASL.Enable)
+			assert.Equal(t, sarama.SASLMechanism(mechanism), config.Net.SASL.Mechanism)
+
+			// Run the client conversation against a SCRAM server holding the same credentials
+			hash := scram.SHA256
+			if mechanism == SASLScramSHA512 {
+				hash = scram.SHA512
+			}
+			serverClient, err := hash.NewClient("svc", "s3cret", "")
+			require.NoError(t, err)
+			creds := serverClient.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096})
+			server, err := hash.NewServer(func(string) (scram.StoredCredentials, error) { return creds, nil })
+			require.NoError(t, err)
+			serverConv := server.NewConversation()
+
+			client := config.Net.SASL.SCRAMClientGeneratorFunc()
+			require.NoError(t, client.Begin("svc", "s3cret", ""))
+			challenge := ""
+			for !client.Done() {
+				response, err := client.Step(challenge)
+				require.NoError(t, err)
+				if client.Done() {
+					break
+				}
+				challenge, err = serverConv.Step(response)
+				require.NoError(t, err)
+			}
+			assert.True(t, serverConv.Valid())
+		})
+	}
+}
+
+type staticTokenProvider string
+
+func (p staticTokenProvider) Token(context.Context) (string, error) {
+	return string(p), nil
+}
+
+func TestSecurityConfig_OAuthBearer(t *testing.T) {
+	config, err := newSaramaConsumerConfig(ConsumerConfig{
+		Security: SecurityConfig{SASL: &SASLConfig{Mechanism: SASLOAuthBearer, TokenProvider: staticTokenProvider("jwt")}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, config.Validate())
+
+	token, err := config.Net.SASL.TokenProvider.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "jwt", token.Token)
+}
+
+func TestSecurityConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  SecurityConfig
+	}{
+		{"CertWithoutKey", SecurityConfig{TLS: &TLSConfig{CertFile: "client.pem"}}},
+		{"TLS11", SecurityConfig{TLS: &TLSConfig{MinVersion: tls.VersionTLS11}}},
+		{"PlainWithoutPassword", SecurityConfig{SASL: &SASLConfig{Mechanism: SASLPlain, Username: "svc"}}},
+		{"OAuthWithoutProvider", SecurityConfig{SASL: &SASLConfig{Mechanism: SASLOAuthBearer}}},
+		{"UnknownMechanism", SecurityConfig{SASL: &SASLConfig{Mechanism: "GSSAPI"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.cfg.Validate())
+		})
+	}
+}
+
+func TestSecurityConfigFromEnv(t *testing.T) {
+	pki := newTestPKI(t)
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("s3cret\n"), 0o600))
+
+	t.Setenv("KAFKA_TLS_CA_FILE", pki.caFile)
+	t.Setenv("KAFKA_TLS_MIN_VERSION", "1.3")
+	t.Setenv("KAFKA_SASL_MECHANISM", "scram-sha-512")
+	t.Setenv("KAFKA_SASL_USERNAME", "svc")
+	t.Setenv("KAFKA_SASL_PASSWORD_FILE", passwordFile)
+
+	cfg, err := SecurityConfigFromEnv("KAFKA")
+	require.NoError(t, err)
+	require.NotNil(t, cfg.TLS)
+	assert.Equal(t, pki.caFile, cfg.TLS.CAFile)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.TLS.MinVersion)
+	require.NotNil(t, cfg.SASL)
+	assert.Equal(t, SASLScramSHA512, cfg.SASL.Mechanism)
+	assert.Equal(t, "s3cret", cfg.SASL.Password)
+
+	t.Setenv("KAFKA_TLS_MIN_VERSION", "1.0")
+	_, err = SecurityConfigFromEnv("KAFKA")
+	assert.Error(t, err)
+}
+
+func TestSecurityConfigFromEnv_Plaintext(t *testing.T) {
+	cfg, err := SecurityConfigFromEnv("KAFKA_UNSET")
+	require.NoError(t, err)
+	assert.Nil(t, cfg.TLS)
+	assert.Nil(t, cfg.SASL)
+}
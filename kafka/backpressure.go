@@ -0,0 +1,289 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BackpressureConfig pauses every partition of a consumer while its handlers
+// are failing or saturated, and resumes after a cool-down. Pausing stops
+// fetching without leaving the group; messages already fetched are still handled.
+type BackpressureConfig struct {
+	// MaxErrorRate pauses when the ratio of messages (or batches) that failed
+	// after their retries in Window exceeds it; 0 disables
+	MaxErrorRate float64
+	// MinRequests is the number of messages (or batches) in Window before MaxErrorRate applies
+	MinRequests int
+	// Window is the period over which the error rate is measured
+	Window time.Duration
+	// MaxInFlight pauses when more messages than this are being handled at once; 0 disables
+	MaxInFlight int
+	// CoolDown is how long the consumer stays paused before resuming
+	CoolDown time.Duration
+	// OnStateChange is called when backpressure pauses or resumes the consumer
+	OnStateChange func(paused bool, reason string)
+}
+
+// DefaultBackpressureConfig returns backpressure that pauses for 30s when
+// more than half of at least 20 handler calls in 30s fail
+func DefaultBackpressureConfig() BackpressureConfig {
+	return BackpressureConfig{
+		MaxErrorRate: 0.5,
+		MinRequests:  20,
+		Window:       30 * time.Second,
+		CoolDown:     30 * time.Second,
+	}
+}
+
+// Validate checks that at least one threshold is set and values are in range
+func (cfg BackpressureConfig) Validate() error {
+	if cfg.MaxErrorRate == 0 && cfg.MaxInFlight == 0 {
+		return errors.New("backpressure requires max error rate or max in-flight")
+	}
+	if cfg.MaxErrorRate < 0 || cfg.MaxErrorRate > 1 {
+		return fmt.Errorf("backpressure max error rate must be between 0 and 1, got %v", cfg.MaxErrorRate)
+	}
+	if cfg.MaxInFlight < 0 || cfg.MinRequests < 0 || cfg.Window < 0 || cfg.CoolDown < 0 {
+		return errors.New("backpressure thresholds must not be negative")
+	}
+	return nil
+}
+
+// withDefaults fills zero durations and MinRequests from DefaultBackpressureConfig
+func (cfg BackpressureConfig) withDefaults() BackpressureConfig {
+	defaults := DefaultBackpressureConfig()
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = defaults.MinRequests
+	}
+	if cfg.Window == 0 {
+		cfg.Window = defaults.Window
+	}
+	if cfg.CoolDown == 0 {
+		cfg.CoolDown = defaults.CoolDown
+	}
+	return cfg
+}
+
+// pauseState tracks why partitions are paused, so that pauses are reapplied
+// to new claims after a rebalance and an automatic resume keeps manual pauses
+type pauseState struct {
+	mu         sync.Mutex
+	all        bool
+	auto       bool
+	partitions map[string]map[int32]struct{}
+}
+
+func (s *pauseState) paused(topic string, partition int32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.all || s.auto {
+		return true
+	}
+	_, ok := s.partitions[topic][partition]
+	return ok
+}
+
+// manual returns the individually paused partitions
+func (s *pauseState) manual() map[string][]int32 {
+	out := make(map[string][]int32, len(s.partitions))
+	for topic, ps := range s.partitions {
+		for p := range ps {
+			out[topic] = append(out[topic], p)
+		}
+	}
+	return out
+}
+
+// Pause stops fetching from partitions of topic without leaving the group.
+// Pauses are kept across rebalances until resumed.
+func (c *Consumer) Pause(topic string, partitions ...int32) {
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+	if c.pause.partitions == nil {
+		c.pause.partitions = make(map[string]map[int32]struct{})
+	}
+	if c.pause.partitions[topic] == nil {
+		c.pause.partitions[topic] = make(map[int32]struct{})
+	}
+	for _, p := range partitions {
+		c.pause.partitions[topic][p] = struct{}{}
+	}
+	c.client.Pause(map[string][]int32{topic: partitions})
+}
+
+// Resume resumes fetching from partitions of topic paused by Pause. Partitions
+// stay paused while PauseAll or backpressure is in effect.
+func (c *Consumer) Resume(topic string, partitions ...int32) {
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+	for _, p := range partitions {
+		delete(c.pause.partitions[topic], p)
+	}
+	if !c.pause.all && !c.pause.auto {
+		c.client.Resume(map[string][]int32{topic: partitions})
+	}
+}
+
+// PauseAll stops fetching from every assigned partition until ResumeAll
+func (c *Consumer) PauseAll() {
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+	c.pause.all = true
+	c.client.PauseAll()
+}
+
+// ResumeAll resumes every partition paused by Pause or PauseAll. Partitions
+// stay paused while backpressure is in effect.
+func (c *Consumer) ResumeAll() {
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+	c.pause.all = false
+	c.pause.partitions = nil
+	if !c.pause.auto {
+		c.client.ResumeAll()
+	}
+}
+
+// reapplyPause pauses a newly claimed partition that should stay paused
+func (c *Consumer) reapplyPause(topic string, partition int32) {
+	if c.pause.paused(topic, partition) {
+		c.client.Pause(map[string][]int32{topic: {partition}})
+	}
+}
+
+// backpressure pauses a consumer while handlers fail or saturate
+type backpressure struct {
+	cfg    BackpressureConfig
+	pause  func(reason string)
+	resume func()
+
+	mu          sync.Mutex
+	inFlight    int
+	windowStart time.Time
+	total       int
+	failed      int
+	paused      bool
+	timer       *time.Timer
+	now         func() time.Time
+}
+
+func newBackpressure(cfg BackpressureConfig, pause func(reason string), resume func()) *backpressure {
+	return &backpressure{cfg: cfg.withDefaults(), pause: pause, resume: resume, now: time.Now}
+}
+
+// begin records a message entering the handler. A nil *backpressure does nothing.
+func (b *backpressure) begin() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inFlight++
+	if b.cfg.MaxInFlight > 0 && b.inFlight > b.cfg.MaxInFlight {
+		b.trip(fmt.Sprintf("%d messages in flight", b.inFlight))
+	}
+}
+
+// end records a message leaving the handler
+func (b *backpressure) end() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inFlight--
+}
+
+// record counts the final outcome of one message towards the error rate
+func (b *backpressure) record(err error) {
+	if b == nil || b.cfg.MaxErrorRate == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if now.Sub(b.windowStart) > b.cfg.Window {
+		b.windowStart = now
+		b.total, b.failed = 0, 0
+	}
+	b.total++
+	if err != nil {
+		b.failed++
+	}
+	if b.total >= b.cfg.MinRequests {
+		if rate := float64(b.failed) / float64(b.total); rate > b.cfg.MaxErrorRate {
+			b.trip(fmt.Sprintf("handler error rate %.2f", rate))
+		}
+	}
+}
+
+// trip pauses the consumer for the cool-down; b.mu must be held
+func (b *backpressure) trip(reason string) {
+	if b.paused {
+		return
+	}
+	b.paused = true
+	b.pause(reason)
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(true, reason)
+	}
+	b.timer = time.AfterFunc(b.cfg.CoolDown, b.coolDown)
+}
+
+func (b *backpressure) coolDown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.paused {
+		return
+	}
+	b.paused = false
+	b.windowStart = b.now()
+	b.total, b.failed = 0, 0
+	b.resume()
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(false, "cool-down elapsed")
+	}
+}
+
+// stop cancels a pending resume
+func (b *backpressure) stop() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.paused = false
+}
+
+// newConsumerBackpressure wires backpressure to the consumer's pause state
+func (c *Consumer) newConsumerBackpressure(cfg BackpressureConfig) *backpressure {
+	return newBackpressure(cfg,
+		func(reason string) {
+			c.logger.Warn("Consumer paused by backpressure", zap.String("reason", reason))
+			c.pause.mu.Lock()
+			defer c.pause.mu.Unlock()
+			c.pause.auto = true
+			c.client.PauseAll()
+		},
+		func() {
+			c.logger.Info("Consumer resumed after backpressure cool-down")
+			c.pause.mu.Lock()
+			defer c.pause.mu.Unlock()
+			c.pause.auto = false
+			if !c.pause.all {
+				c.client.ResumeAll()
+				if manual := c.pause.manual(); len(manual) > 0 {
+					c.client.Pause(manual)
+				}
+			}
+		},
+	)
+}
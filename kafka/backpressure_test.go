@@ -0,0 +1,148 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackpressureConfig_Validate(t *testing.T) {
+	assert.NoError(t, DefaultBackpressureConfig().Validate())
+	assert.NoError(t, BackpressureConfig{MaxInFlight: 10}.Validate())
+
+	assert.Error(t, BackpressureConfig{}.Validate(), "no threshold")
+	assert.Error(t, BackpressureConfig{MaxErrorRate: 1.5}.Validate())
+	assert.Error(t, BackpressureConfig{MaxInFlight: 10, CoolDown: -time.Second}.Validate())
+	assert.Error(t, ConsumerConfig{Backpressure: &BackpressureConfig{}}.validate())
+}
+
+func TestConsumer_PauseAndResume(t *testing.T) {
+	group := newFakeGroup(nil)
+	c := newTestConsumer(t, nil, nil)
+	c.client = group
+
+	c.Pause("banking.transactions.initiated", 1, 2)
+	c.PauseAll()
+	// Partitions stay paused while PauseAll is in effect
+	c.Resume("banking.transactions.initiated", 1)
+	assert.True(t, c.pause.paused("banking.transactions.initiated", 1))
+	c.ResumeAll()
+	assert.False(t, c.pause.paused("banking.transactions.initiated", 2))
+
+	assert.Equal(t, []string{
+		"pause map[banking.transactions.initiated:[1 2]]",
+		"pause all",
+		"resume all",
+	}, group.pauseCalls())
+}
+
+func TestConsumer_ReappliesPauseToNewClaims(t *testing.T) {
+	group := newFakeGroup(nil)
+	c := newTestConsumer(t, func(context.Context, *sarama.ConsumerMessage) error { return nil }, nil)
+	c.client = group
+
+	c.Pause("banking.transactions.initiated", 2)
+	group.pauseCalls()
+
+	// A rebalance hands out new partition consumers, which start unpaused
+	require.NoError(t, c.ConsumeClaim(newFakeSession(context.Background()), newFakeClaim("banking.transactions.initiated", 2)))
+	require.NoError(t, c.ConsumeClaim(newFakeSession(context.Background()), newFakeClaim("banking.transactions.initiated", 3)))
+	assert.Equal(t, []string{"pause map[banking.transactions.initiated:[2]]"}, group.pauseCalls())
+}
+
+func TestConsumer_BackpressurePausesOnErrorRate(t *testing.T) {
+	group := newFakeGroup(nil)
+	var (
+		mu      sync.Mutex
+		changes []bool
+	)
+	producer, mockProducer := newTestProducer(t)
+	for i := 0; i < 3; i++ {
+		mockProducer.ExpectSendMessageAndSucceed()
+	}
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset%2 == 0 {
+			return NonRetryable(errors.New("core banking unavailable"))
+		}
+		return nil
+	}, DefaultDeadLetterPolicy(producer))
+	c.client = group
+	c.backpressure = c.newConsumerBackpressure(BackpressureConfig{
+		MaxErrorRate: 0.4,
+		MinRequests:  4,
+		CoolDown:     20 * time.Millisecond,
+		OnStateChange: func(paused bool, reason string) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, paused)
+		},
+	})
+	c.Pause("banking.transactions.initiated", 7)
+	group.pauseCalls()
+
+	session := newFakeSession(context.Background())
+	require.NoError(t, c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2,
+		testMessage(1), testMessage(2), testMessage(3), testMessage(4), testMessage(5), testMessage(6))))
+	assert.Equal(t, []string{"pause all"}, group.pauseCalls(), "pauses once when 2 of 4 calls fail")
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(changes) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []bool{true, false}, changes)
+	// Resuming after the cool-down keeps the manual pause
+	assert.Equal(t, []string{"resume all", "pause map[banking.transactions.initiated:[7]]"}, group.pauseCalls())
+}
+
+func TestConsumer_BackpressureCountsMessagesNotAttempts(t *testing.T) {
+	group := newFakeGroup(nil)
+	producer, _ := newTestProducer(t)
+	policy := DefaultDeadLetterPolicy(producer)
+	policy.Backoff = 0
+
+	attempts := map[int64]int{}
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		attempts[msg.Offset]++
+		if attempts[msg.Offset] < 3 {
+			return errors.New("core banking unavailable")
+		}
+		return nil
+	}, policy)
+	c.client = group
+	c.backpressure = c.newConsumerBackpressure(BackpressureConfig{
+		MaxErrorRate: 0.4,
+		MinRequests:  2,
+		CoolDown:     time.Hour,
+	})
+
+	session := newFakeSession(context.Background())
+	require.NoError(t, c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(1), testMessage(2))))
+	assert.Equal(t, []int64{1, 2}, session.markedOffsets())
+	assert.Empty(t, group.pauseCalls(), "messages that succeed on a retry are not failures")
+}
+
+func TestBackpressure_PausesOnInFlight(t *testing.T) {
+	var reasons []string
+	b := newBackpressure(BackpressureConfig{MaxInFlight: 2, CoolDown: time.Hour},
+		func(reason string) { reasons = append(reasons, reason) },
+		func() {},
+	)
+	defer b.stop()
+
+	b.begin()
+	b.begin()
+	b.end()
+	b.begin()
+	assert.Empty(t, reasons)
+
+	b.begin()
+	b.begin()
+	assert.Equal(t, []string{"3 messages in flight"}, reasons, "pauses once until the cool-down")
+}
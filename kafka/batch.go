@@ -0,0 +1,47 @@
+package kafka
+
+import "fmt"
+
+// BatchResult reports the outcome of each event passed to PublishBatch, in input order.
+//
+// A batch is not atomic. Events whose result has a nil Err were acknowledged by
+// the broker and must not be published again; events with a non-nil Err were
+// not written (or the producer could not confirm the write) and may be retried
+// by passing FailedEvents back to PublishBatch. Ordering between retried and
+// already written events of the same key is not preserved.
+//
+// While the producer's spool holds messages, the whole batch is spooled behind
+// them; spooled events have a nil Err and a Partition and Offset of -1.
+type BatchResult struct {
+	Results []PublishResult
+}
+
+// Failed returns the input indexes of the events that failed
+func (r *BatchResult) Failed() []int {
+	var failed []int
+	for i, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, i)
+		}
+	}
+	return failed
+}
+
+// FailedEvents returns the failed events from the original input, in order
+func (r *BatchResult) FailedEvents(events []Event) []Event {
+	var out []Event
+	for _, i := range r.Failed() {
+		out = append(out, events[i])
+	}
+	return out
+}
+
+// Err returns an error summarizing the failed events, or nil if all succeeded
+func (r *BatchResult) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d events failed to publish, first at index %d: %w",
+		len(failed), len(r.Results), failed[0], r.Results[failed[0]].Err)
+}
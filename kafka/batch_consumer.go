@@ -0,0 +1,292 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// BatchHandler processes a batch of messages from one partition, in offset order
+type BatchHandler func(ctx context.Context, msgs []*sarama.ConsumerMessage) error
+
+// BatchConfig bounds the batches passed to a BatchHandler
+type BatchConfig struct {
+	// Size is the maximum number of messages per batch
+	Size int
+	// MaxWait is how long a partial batch waits for more messages after its first one
+	MaxWait time.Duration
+}
+
+// DefaultBatchConfig returns batches of up to 100 messages or 1s
+func DefaultBatchConfig() BatchConfig {
+	return BatchConfig{Size: 100, MaxWait: time.Second}
+}
+
+// BatchError reports the messages of a batch that failed, by index into the
+// batch. Returning it from a BatchHandler marks every other message as done.
+// An empty BatchError, or one with an index outside the batch, fails the
+// whole batch.
+type BatchError struct {
+	Failed map[int]error
+}
+
+// NewBatchError returns an empty BatchError to add failures to
+func NewBatchError() *BatchError {
+	return &BatchError{Failed: make(map[int]error)}
+}
+
+// Add records that the message at index failed with err
+func (e *BatchError) Add(index int, err error) {
+	e.Failed[index] = err
+}
+
+// Indexes returns the failed indexes in ascending order
+func (e *BatchError) Indexes() []int {
+	indexes := make([]int, 0, len(e.Failed))
+	for i := range e.Failed {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+func (e *BatchError) Error() string {
+	indexes := e.Indexes()
+	msgs := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		msgs = append(msgs, fmt.Sprintf("%d: %v", i, e.Failed[i]))
+	}
+	return fmt.Sprintf("%d messages in batch failed: %s", len(indexes), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the individual errors
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, i := range e.Indexes() {
+		errs = append(errs, e.Failed[i])
+	}
+	return errs
+}
+
+// batcher holds the batch handler of a batch consumer
+type batcher struct {
+	cfg     BatchConfig
+	handler BatchHandler
+}
+
+// NewBatchConsumer creates a consumer that passes messages to handler in
+// batches of up to batch.Size messages or batch.MaxWait per partition.
+//
+// A batch is committed once every message in it is done. Failed messages
+// (all of them when handler returns a plain error, or the indexes of a
+// *BatchError) are then handled one at a time as single-message batches with
+// the retry and dead-letter policies of cfg. If a failed message is still not
+// done, the batch is not committed and the session ends so that it is redelivered.
+func NewBatchConsumer(cfg ConsumerConfig, batch BatchConfig, handler BatchHandler, logger *zap.Logger) (*Consumer, error) {
+	if cfg.Concurrency > 1 {
+		return nil, errors.New("batch consumer does not support concurrency")
+	}
+	if batch.Size < 0 || batch.MaxWait < 0 {
+		return nil, errors.New("batch size and max wait must not be negative")
+	}
+	defaults := DefaultBatchConfig()
+	if batch.Size == 0 {
+		batch.Size = defaults.Size
+	}
+	if batch.MaxWait == 0 {
+		batch.MaxWait = defaults.MaxWait
+	}
+
+	c, err := NewConsumer(cfg, nil, logger)
+	if err != nil {
+		return nil, err
+	}
+	c.batch = &batcher{cfg: batch, handler: handler}
+	return c, nil
+}
+
+// consumeBatches accumulates messages into batches until the claim ends
+func (c *Consumer) consumeBatches(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	batch := make([]*sarama.ConsumerMessage, 0, c.batch.cfg.Size)
+	timer := time.NewTimer(c.batch.cfg.MaxWait)
+	timer.Stop()
+	defer timer.Stop()
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		timer.Stop()
+		done, err := c.processBatch(session.Context(), batch)
+		if err != nil {
+			return err
+		}
+		if !done {
+			return fmt.Errorf("failed to process batch from %s/%d@%d-%d",
+				claim.Topic(), claim.Partition(), batch[0].Offset, batch[len(batch)-1].Offset)
+		}
+		session.MarkMessage(batch[len(batch)-1], "")
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return flush()
+			}
+			batch = append(batch, message)
+			if len(batch) == 1 {
+				timer.Reset(c.batch.cfg.MaxWait)
+			}
+			if len(batch) >= c.batch.cfg.Size {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+
+		case <-timer.C:
+			if err := flush(); err != nil {
+				return err
+			}
+
+		case <-session.Context().Done():
+			// The partial batch is uncommitted and will be redelivered
+			return nil
+		}
+	}
+}
+
+// processBatch runs the batch handler and falls back to single-message
+// processing for failed messages. It reports whether every message is done.
+func (c *Consumer) processBatch(sessionCtx context.Context, batch []*sarama.ConsumerMessage) (bool, error) {
+	c.health.messageReceived()
+	c.backpressure.begin()
+	ctx, span := c.startBatchSpan(sessionCtx, batch)
+	start := time.Now()
+	err := callBatchHandler(ctx, c.batch.handler, batch, c.logger)
+	for _, msg := range batch {
+		c.metrics.recordDelivery(ctx, msg, start, err)
+	}
+	c.backpressure.record(err)
+	c.backpressure.end()
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+
+	if err == nil {
+		return true, nil
+	}
+	if sessionCtx.Err() != nil {
+		// The session is ending; the batch is redelivered to the next owner
+		return false, nil
+	}
+
+	failed := batchFailures(err, len(batch))
+	if failed == nil {
+		c.logger.Error("Invalid batch error, failing the whole batch",
+			zap.String("topic", batch[0].Topic),
+			zap.Int32("partition", batch[0].Partition),
+			zap.Int64("first_offset", batch[0].Offset),
+			zap.Int("batch_size", len(batch)),
+			zap.Error(err),
+		)
+		failed = allIndexes(len(batch))
+	}
+
+	c.logger.Warn("Batch partially failed, processing failed messages individually",
+		zap.String("topic", batch[0].Topic),
+		zap.Int32("partition", batch[0].Partition),
+		zap.Int64("first_offset", batch[0].Offset),
+		zap.Int("batch_size", len(batch)),
+		zap.Int("failed", len(failed)),
+		zap.Error(err),
+	)
+
+	single := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		err := c.batch.handler(ctx, []*sarama.ConsumerMessage{msg})
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) && len(batchErr.Failed) > 0 {
+			return batchErr.Failed[batchErr.Indexes()[0]]
+		}
+		return err
+	}
+	for _, i := range failed {
+		done, err := c.processWith(sessionCtx, batch[i], single)
+		if err != nil || !done {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// batchFailures returns the indexes of a batch of size n that failed with
+// err: every index for a plain error, or those of a *BatchError. It returns
+// nil for a *BatchError that is empty or names an index outside the batch.
+func batchFailures(err error, n int) []int {
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		return allIndexes(n)
+	}
+	indexes := batchErr.Indexes()
+	if len(indexes) == 0 {
+		return nil
+	}
+	for _, i := range indexes {
+		if i < 0 || i >= n {
+			return nil
+		}
+	}
+	return indexes
+}
+
+// allIndexes returns the indexes of a batch of size n
+func allIndexes(n int) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
+
+// callBatchHandler runs handler, recovering a panic into an error
+func callBatchHandler(ctx context.Context, handler BatchHandler, batch []*sarama.ConsumerMessage, logger *zap.Logger) error {
+	return callHandler(ctx, func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+		return handler(ctx, batch)
+	}, batch[0], logger)
+}
+
+// startBatchSpan starts a span for a batch linked to the context of each
+// message; like startConsumeSpan, its context ends with the session
+func (c *Consumer) startBatchSpan(sessionCtx context.Context, batch []*sarama.ConsumerMessage) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(batch))
+	for _, msg := range batch {
+		msgCtx := propagator().Extract(context.Background(), consumerCarrier{msg})
+		if sc := trace.SpanContextFromContext(msgCtx); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+
+	return c.tracer.Start(sessionCtx, "kafka.consume.batch",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationDeliver,
+			semconv.MessagingDestinationName(batch[0].Topic),
+			semconv.MessagingKafkaDestinationPartition(int(batch[0].Partition)),
+			semconv.MessagingKafkaConsumerGroup(c.groupID),
+			semconv.MessagingBatchMessageCount(len(batch)),
+		),
+	)
+}
@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchRecorder records the offsets of each batch passed to a BatchHandler
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]int64
+}
+
+func (r *batchRecorder) record(msgs []*sarama.ConsumerMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	offsets := make([]int64, 0, len(msgs))
+	for _, m := range msgs {
+		offsets = append(offsets, m.Offset)
+	}
+	r.batches = append(r.batches, offsets)
+}
+
+func (r *batchRecorder) recorded() [][]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.batches
+}
+
+func newTestBatchConsumer(t *testing.T, cfg BatchConfig, handler BatchHandler, dlq *DeadLetterPolicy) *Consumer {
+	c := newTestConsumer(t, nil, dlq)
+	c.batch = &batcher{cfg: cfg, handler: handler}
+	return c
+}
+
+func TestBatchConsumer_BatchesBySize(t *testing.T) {
+	var rec batchRecorder
+	c := newTestBatchConsumer(t, BatchConfig{Size: 2, MaxWait: time.Minute}, func(ctx context.Context, msgs []*sarama.ConsumerMessage) error {
+		rec.record(msgs)
+		return nil
+	}, nil)
+	session := newFakeSession(context.Background())
+
+	claim := newFakeClaim("banking.audit", 0, testMessage(1), testMessage(2), testMessage(3), testMessage(4), testMessage(5))
+	require.NoError(t, c.ConsumeClaim(session, claim))
+
+	assert.Equal(t, [][]int64{{1, 2}, {3, 4}, {5}}, rec.recorded())
+	assert.Equal(t, []int64{2, 4, 5}, session.markedOffsets())
+}
+
+func TestBatchConsumer_FlushesAfterMaxWait(t *testing.T) {
+	var rec batchRecorder
+	c := newTestBatchConsumer(t, BatchConfig{Size: 100, MaxWait: 20 * time.Millisecond}, func(ctx context.Context, msgs []*sarama.ConsumerMessage) error {
+		rec.record(msgs)
+		return nil
+	}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	session := newFakeSession(ctx)
+	claim := &fakeClaim{topic: "banking.audit", messages: make(chan *sarama.ConsumerMessage, 2)}
+
+	done := make(chan error, 1)
+	go func() { done <- c.ConsumeClaim(session, claim) }()
+
+	claim.messages <- testMessage(1)
+	claim.messages <- testMessage(2)
+	assert.Eventually(t, func() bool { return len(rec.recorded()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, [][]int64{{1, 2}}, rec.recorded())
+	assert.Equal(t, []int64{2}, session.markedOffsets())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestBatchConsumer_PartialFailureDeadLettersFailedIndexes(t *testing.T) {
+	producer, mockProducer := newTestProducer(t)
+	policy := DefaultDeadLetterPolicy(producer)
+	policy.Backoff = 0
+
+	var rec batchRecorder
+	c := newTestBatchConsumer(t, BatchConfig{Size: 3, MaxWait: time.Minute}, func(ctx context.Context, msgs []*sarama.ConsumerMessage) error {
+		rec.record(msgs)
+		batchErr := NewBatchError()
+		for i, msg := range msgs {
+			if msg.Offset == 2 {
+				batchErr.Add(i, NonRetryable(errors.New("invalid row")))
+			}
+		}
+		if len(batchErr.Failed) > 0 {
+			return batchErr
+		}
+		return nil
+	}, policy)
+	session := newFakeSession(context.Background())
+
+	var published *sarama.ProducerMessage
+	mockProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		published = msg
+		return nil
+	})
+
+	require.NoError(t, c.ConsumeClaim(session, newFakeClaim("banking.audit", 0, testMessage(1), testMessage(2), testMessage(3))))
+
+	// Only the failed message is retried on its own before it is dead-lettered
+	assert.Equal(t, [][]int64{{1, 2, 3}, {2}}, rec.recorded())
+	require.NotNil(t, published)
+	assert.Equal(t, "2", headerMap(published.Headers)[HeaderDLQOriginalOffset])
+	assert.Equal(t, []int64{3}, session.markedOffsets())
+}
+
+func TestBatchConsumer_FailureWithoutDeadLetterEndsSession(t *testing.T) {
+	c := newTestBatchConsumer(t, BatchConfig{Size: 2, MaxWait: time.Minute}, func(ctx context.Context, msgs []*sarama.ConsumerMessage) error {
+		return errors.New("warehouse unavailable")
+	}, nil)
+	session := newFakeSession(context.Background())
+
+	err := c.ConsumeClaim(session, newFakeClaim("banking.audit", 0, testMessage(1), testMessage(2)))
+	require.ErrorContains(t, err, "failed to process batch")
+	assert.Empty(t, session.markedOffsets(), "the batch is redelivered")
+}
+
+func TestBatchConsumer_InvalidBatchErrorFailsWholeBatch(t *testing.T) {
+	for name, batchErr := range map[string]func() *BatchError{
+		"empty": NewBatchError,
+		"out of range": func() *BatchError {
+			batchErr := NewBatchError()
+			batchErr.Add(5, errors.New("bad row"))
+			return batchErr
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var rec batchRecorder
+			c := newTestBatchConsumer(t, BatchConfig{Size: 2, MaxWait: time.Minute}, func(ctx context.Context, msgs []*sarama.ConsumerMessage) error {
+				rec.record(msgs)
+				return batchErr()
+			}, nil)
+			session := newFakeSession(context.Background())
+
+			err := c.ConsumeClaim(session, newFakeClaim("banking.audit", 0, testMessage(1), testMessage(2)))
+			require.ErrorContains(t, err, "failed to process batch")
+			assert.Empty(t, session.markedOffsets(), "the batch is redelivered")
+			assert.Equal(t, [][]int64{{1, 2}, {1}}, rec.recorded(), "every message is retried on its own")
+		})
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	errBadRow := errors.New("bad row")
+	batchErr := NewBatchError()
+	batchErr.Add(3, errors.New("timeout"))
+	batchErr.Add(1, errBadRow)
+
+	assert.Equal(t, []int{1, 3}, batchErr.Indexes())
+	assert.EqualError(t, batchErr, "2 messages in batch failed: 1: bad row; 3: timeout")
+	assert.ErrorIs(t, batchErr, errBadRow)
+}
@@ -0,0 +1,203 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+)
+
+// workerQueueSize bounds the messages buffered per worker before dispatch blocks
+const workerQueueSize = 64
+
+// maxPendingMessages bounds the dispatched messages of a claim that are not
+// yet below the commit watermark; dispatch blocks once it is reached
+const maxPendingMessages = 1024
+
+// offsetTracker tracks in-flight offsets for a partition claim and marks the
+// commit watermark: the highest offset below which every message is done.
+type offsetTracker struct {
+	mu      sync.Mutex
+	session sarama.ConsumerGroupSession
+	pending []*sarama.ConsumerMessage // dispatched messages in offset order
+	done    map[int64]bool
+	failed  map[int64]bool
+}
+
+func newOffsetTracker(session sarama.ConsumerGroupSession) *offsetTracker {
+	return &offsetTracker{
+		session: session,
+		done:    make(map[int64]bool),
+		failed:  make(map[int64]bool),
+	}
+}
+
+// add registers a dispatched message; messages must be added in offset order
+func (t *offsetTracker) add(msg *sarama.ConsumerMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, msg)
+}
+
+// complete records a finished message and advances the watermark past every
+// contiguous finished offset. It returns how many messages left pending and
+// the oldest pending message if it has failed.
+func (t *offsetTracker) complete(msg *sarama.ConsumerMessage) (int, *sarama.ConsumerMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.done[msg.Offset] = true
+
+	var watermark *sarama.ConsumerMessage
+	released := 0
+	for len(t.pending) > 0 && t.done[t.pending[0].Offset] {
+		watermark = t.pending[0]
+		delete(t.done, watermark.Offset)
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+		released++
+	}
+	if watermark != nil {
+		t.session.MarkMessage(watermark, "")
+	}
+	return released, t.failedHead()
+}
+
+// fail records a message that was not done and returns the oldest pending
+// message if it has failed. A failed message holds the watermark for good.
+func (t *offsetTracker) fail(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failed[msg.Offset] = true
+	return t.failedHead()
+}
+
+// failedHead returns the oldest pending message if it has failed; t.mu must be held
+func (t *offsetTracker) failedHead() *sarama.ConsumerMessage {
+	if len(t.pending) > 0 && t.failed[t.pending[0].Offset] {
+		return t.pending[0]
+	}
+	return nil
+}
+
+// consumeConcurrently processes a partition claim with a pool of workers,
+// routing messages by key so per-key ordering is preserved. On rebalance it
+// stops dispatching, lets in-flight handlers finish and marks the final
+// watermark; queued messages that never started are redelivered to the next owner.
+//
+// At most maxPendingMessages messages are dispatched ahead of the watermark.
+// A message that fails without being retried or dead-lettered holds the
+// watermark, so once every message before it is done the claim ends with an
+// error and the message is redelivered from the last committed offset.
+func (c *Consumer) consumeConcurrently(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+
+	tracker := newOffsetTracker(session)
+	slots := make(chan struct{}, maxPendingMessages)
+	release := func(n int) {
+		for i := 0; i < n; i++ {
+			<-slots
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		fatalErr error
+	)
+	stop := func(err error) {
+		errOnce.Do(func() {
+			fatalErr = err
+			cancel()
+		})
+	}
+	stopIfFailed := func(head *sarama.ConsumerMessage) {
+		if head != nil {
+			stop(fmt.Errorf("failed to process message from %s/%d@%d", head.Topic, head.Partition, head.Offset))
+		}
+	}
+
+	queues := make([]chan *sarama.ConsumerMessage, c.workers)
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, workerQueueSize)
+		wg.Add(1)
+		go func(queue <-chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for message := range queue {
+				if ctx.Err() != nil {
+					// Draining: leave the message uncommitted for redelivery
+					continue
+				}
+				done, err := c.process(ctx, message)
+				switch {
+				case err != nil:
+					stop(err)
+				case done:
+					released, head := tracker.complete(message)
+					release(released)
+					stopIfFailed(head)
+				case ctx.Err() == nil:
+					// Failed without a dead-letter policy, not interrupted by the session ending
+					stopIfFailed(tracker.fail(message))
+				}
+			}
+		}(queues[i])
+	}
+
+	dispatch := func() {
+		for {
+			select {
+			case message, ok := <-claim.Messages():
+				if !ok {
+					return
+				}
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				tracker.add(message)
+				select {
+				case queues[workerIndex(message, c.workers)] <- message:
+				case <-ctx.Done():
+					return
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	dispatch()
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	if fatalErr != nil {
+		c.logger.Error("Stopping partition claim after fatal error",
+			zap.String("topic", claim.Topic()),
+			zap.Int32("partition", claim.Partition()),
+			zap.Error(fatalErr),
+		)
+	}
+	return fatalErr
+}
+
+// workerSeed seeds key hashing. It must differ from the producer's FNV-1a
+// partitioner: keys sharing a partition share hash%partitions, which would
+// otherwise pile them onto one worker whenever workers and partitions share a factor.
+var workerSeed = maphash.MakeSeed()
+
+// workerIndex maps a message to a worker by key; keyless messages are spread by offset
+func workerIndex(msg *sarama.ConsumerMessage, workers int) int {
+	if len(msg.Key) == 0 {
+		return int(msg.Offset % int64(workers))
+	}
+	return int(maphash.Bytes(workerSeed, msg.Key) % uint64(workers))
+}
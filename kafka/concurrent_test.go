@@ -0,0 +1,175 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetTracker_Watermark(t *testing.T) {
+	session := newFakeSession(context.Background())
+	tracker := newOffsetTracker(session)
+
+	msgs := make([]*sarama.ConsumerMessage, 5)
+	for i := range msgs {
+		msgs[i] = testMessage(int64(10 + i))
+		tracker.add(msgs[i])
+	}
+
+	tracker.complete(msgs[1])
+	tracker.complete(msgs[2])
+	assert.Empty(t, session.markedOffsets(), "watermark must wait for offset 10")
+
+	tracker.complete(msgs[0])
+	assert.Equal(t, []int64{12}, session.markedOffsets())
+
+	tracker.complete(msgs[4])
+	assert.Equal(t, []int64{12}, session.markedOffsets())
+
+	tracker.complete(msgs[3])
+	assert.Equal(t, []int64{12, 14}, session.markedOffsets())
+}
+
+func TestConsumer_ConsumeClaim_ConcurrentPerKeyOrdering(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = make(map[string][]int64)
+	)
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		// Later offsets finish faster to shake out ordering bugs
+		time.Sleep(time.Duration(100-msg.Offset) * 50 * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		seen[string(msg.Key)] = append(seen[string(msg.Key)], msg.Offset)
+		return nil
+	}, nil)
+	c.workers = 4
+	session := newFakeSession(context.Background())
+
+	var msgs []*sarama.ConsumerMessage
+	for i := 0; i < 100; i++ {
+		msg := testMessage(int64(i))
+		msg.Key = []byte(fmt.Sprintf("user-%d", i%7))
+		msgs = append(msgs, msg)
+	}
+
+	require.NoError(t, c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, msgs...)))
+
+	for key, offsets := range seen {
+		assert.IsIncreasing(t, offsets, "offsets for %s processed out of order", key)
+	}
+	marked := session.markedOffsets()
+	require.NotEmpty(t, marked)
+	assert.IsIncreasing(t, marked)
+	assert.Equal(t, int64(99), marked[len(marked)-1])
+}
+
+func TestConsumer_ConsumeClaim_ConcurrentHoldsWatermarkOnFailure(t *testing.T) {
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 3 {
+			return assert.AnError
+		}
+		return nil
+	}, nil)
+	c.workers = 3
+	session := newFakeSession(context.Background())
+
+	var msgs []*sarama.ConsumerMessage
+	for i := 0; i < 10; i++ {
+		msgs = append(msgs, testMessage(int64(i)))
+	}
+
+	err := c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, msgs...))
+	assert.ErrorContains(t, err, "banking.transactions.initiated/2@3", "the claim ends once the failed message holds the watermark")
+
+	for _, offset := range session.markedOffsets() {
+		assert.Less(t, offset, int64(3))
+	}
+}
+
+func TestConsumer_ConsumeClaim_ConcurrentFailedHeadBoundsPending(t *testing.T) {
+	var handled atomic.Int64
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		handled.Add(1)
+		if msg.Offset == 0 {
+			return assert.AnError
+		}
+		return nil
+	}, nil)
+	c.workers = 4
+	session := newFakeSession(context.Background())
+
+	messages := make(chan *sarama.ConsumerMessage, 5*maxPendingMessages)
+	for i := 0; i < cap(messages); i++ {
+		messages <- testMessage(int64(i))
+	}
+	close(messages)
+	claim := &fakeClaim{topic: "banking.transactions.initiated", partition: 2, messages: messages}
+
+	err := c.ConsumeClaim(session, claim)
+	assert.ErrorContains(t, err, "banking.transactions.initiated/2@0")
+	assert.Empty(t, session.markedOffsets(), "nothing is committed past the failed head")
+	assert.LessOrEqual(t, handled.Load(), int64(maxPendingMessages), "dispatch stops at the pending bound")
+	assert.NotEmpty(t, messages, "undispatched messages are left for redelivery")
+}
+
+func TestOffsetTracker_FailedHead(t *testing.T) {
+	session := newFakeSession(context.Background())
+	tracker := newOffsetTracker(session)
+
+	msgs := make([]*sarama.ConsumerMessage, 3)
+	for i := range msgs {
+		msgs[i] = testMessage(int64(i))
+		tracker.add(msgs[i])
+	}
+
+	assert.Nil(t, tracker.fail(msgs[1]), "a failure behind the watermark does not block yet")
+	released, head := tracker.complete(msgs[0])
+	assert.Equal(t, 1, released)
+	assert.Same(t, msgs[1], head)
+	assert.Equal(t, []int64{0}, session.markedOffsets())
+}
+
+func TestConsumer_ConsumeClaim_ConcurrentDrainsOnRebalance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	c := newTestConsumer(t, func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 0 {
+			close(started)
+			<-release
+		}
+		return nil
+	}, nil)
+	c.workers = 2
+	session := newFakeSession(ctx)
+
+	messages := make(chan *sarama.ConsumerMessage, 4)
+	first := testMessage(0)
+	first.Key = []byte("user-1")
+	messages <- first
+	claim := &fakeClaim{topic: first.Topic, partition: first.Partition, messages: messages}
+
+	result := make(chan error, 1)
+	go func() { result <- c.ConsumeClaim(session, claim) }()
+
+	<-started
+	cancel()
+	close(release)
+
+	select {
+	case err := <-result:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("claim did not drain after rebalance")
+	}
+	assert.Equal(t, []int64{0}, session.markedOffsets())
+}
@@ -3,12 +3,17 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
@@ -19,6 +24,92 @@ type ConsumerConfig struct {
 	GroupID  string
 	Topics   []string
 	ClientID string
+
+	// DeadLetter routes messages that keep failing to a dead-letter topic.
+	// When nil, a failed message ends its claim uncommitted so it is
+	// redelivered once the partition is claimed again.
+	DeadLetter *DeadLetterPolicy
+
+	// Retry moves failed messages onto delayed retry topics before they are
+	// dead-lettered. Retry topics are consumed by NewRetryConsumer.
+	Retry *RetryPolicy
+
+	// Concurrency is the number of workers per partition claim. Messages with
+	// the same key are processed in order by the same worker, and offsets are
+	// committed only once every earlier offset in the partition is done.
+	// A message that fails without a retry or dead-letter policy ends the
+	// claim once it is the oldest pending one, so it is redelivered.
+	// Values <= 1 process messages sequentially.
+	Concurrency int
+
+	// Security configures TLS and SASL; the zero value connects in plaintext
+	Security SecurityConfig
+
+	// MeterProvider receives handler duration, delivery and lag metrics; nil uses the global provider
+	MeterProvider metric.MeterProvider
+
+	// RebalanceStrategy selects how partitions are assigned; the zero value is RebalanceRoundRobin
+	RebalanceStrategy RebalanceStrategy
+
+	// InstanceID enables static group membership (group.instance.id). A member
+	// that restarts with the same ID within the session timeout gets its
+	// partitions back without a rebalance. It must be unique within the group.
+	InstanceID string
+
+	// OnAssigned is called when a partition is claimed, before any of its
+	// messages are handled. An error ends the session.
+	OnAssigned func(ctx context.Context, assignment PartitionAssignment) error
+
+	// OnRevoked is called when a claim ends, after its last message is handled
+	// and before its offsets are committed. Its ctx outlives the session.
+	OnRevoked func(ctx context.Context, assignment PartitionAssignment) error
+
+	// Backpressure pauses fetching while handlers fail or saturate; nil disables it
+	Backpressure *BackpressureConfig
+
+	// ReplayIdleTimeout is how long Replay waits for the next message of a
+	// partition before giving up on it with ErrReplayIncomplete; 0 uses 5s
+	ReplayIdleTimeout time.Duration
+}
+
+// RebalanceStrategy is a consumer group partition assignment strategy
+type RebalanceStrategy string
+
+const (
+	RebalanceRoundRobin RebalanceStrategy = "roundrobin"
+	RebalanceRange      RebalanceStrategy = "range"
+	RebalanceSticky     RebalanceStrategy = "sticky"
+	// RebalanceCooperativeSticky is rejected: sarama only implements the eager
+	// rebalance protocol. Use RebalanceSticky with InstanceID instead.
+	RebalanceCooperativeSticky RebalanceStrategy = "cooperative-sticky"
+)
+
+// balanceStrategy returns the sarama strategy for s
+func (s RebalanceStrategy) balanceStrategy() (sarama.BalanceStrategy, error) {
+	switch s {
+	case "", RebalanceRoundRobin:
+		return sarama.NewBalanceStrategyRoundRobin(), nil
+	case RebalanceRange:
+		return sarama.NewBalanceStrategyRange(), nil
+	case RebalanceSticky:
+		return sarama.NewBalanceStrategySticky(), nil
+	case RebalanceCooperativeSticky:
+		return nil, errors.New("cooperative-sticky rebalancing is not supported by sarama; use sticky with an instance ID")
+	default:
+		return nil, fmt.Errorf("unknown rebalance strategy %q", s)
+	}
+}
+
+// ErrConsumerStarted is returned when Start is called more than once
+var ErrConsumerStarted = errors.New("kafka consumer was already started")
+
+// PartitionAssignment is a partition claimed by a consumer group session
+type PartitionAssignment struct {
+	Topic      string
+	Partition  int32
+	Generation int32
+	// InitialOffset is the offset consumption starts from
+	InitialOffset int64
 }
 
 // MessageHandler is a function that processes a Kafka message
@@ -26,43 +117,161 @@ type MessageHandler func(ctx context.Context, msg *sarama.ConsumerMessage) error
 
 // Consumer is a Kafka consumer group handler
 type Consumer struct {
-	client  sarama.ConsumerGroup
-	handler MessageHandler
-	logger  *zap.Logger
-	tracer  trace.Tracer
-	topics  []string
-	ready   chan bool
-	cancel  context.CancelFunc
-	wg      sync.WaitGroup
+	client       sarama.ConsumerGroup
+	handler      MessageHandler
+	logger       *zap.Logger
+	tracer       trace.Tracer
+	topics       []string
+	groupID      string
+	deadLetter   *DeadLetterPolicy
+	retry        *RetryPolicy
+	delayed      bool
+	workers      int
+	txn          *transactor
+	batch        *batcher
+	metrics      *consumerMetrics
+	health       consumerHealth
+	onAssigned   func(context.Context, PartitionAssignment) error
+	onRevoked    func(context.Context, PartitionAssignment) error
+	pause        pauseState
+	backpressure *backpressure
+	replayIdle   time.Duration
+	errorsDone   chan struct{}
+	started      atomic.Bool
+	ready        chan struct{}
+	readyOnce    sync.Once
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
 }
 
 // NewConsumer creates a new Kafka consumer
 func NewConsumer(cfg ConsumerConfig, handler MessageHandler, logger *zap.Logger) (*Consumer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	config, err := newSaramaConsumerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := newConsumerMetrics(cfg.MeterProvider, cfg.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	return newConsumer(cfg, client, handler, metrics, logger), nil
+}
+
+// NewConsumerFromClient creates a consumer on an existing consumer group
+// client, e.g. an in-memory one in tests. Stop closes client.
+func NewConsumerFromClient(cfg ConsumerConfig, client sarama.ConsumerGroup, handler MessageHandler, logger *zap.Logger) (*Consumer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	metrics, err := newConsumerMetrics(cfg.MeterProvider, cfg.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	return newConsumer(cfg, client, handler, metrics, logger), nil
+}
+
+// validate checks that the configured failure policies can publish
+func (cfg ConsumerConfig) validate() error {
+	if cfg.DeadLetter != nil && cfg.DeadLetter.Producer == nil {
+		return errors.New("dead-letter policy requires a producer")
+	}
+	if cfg.Retry != nil && cfg.Retry.Producer == nil {
+		return errors.New("retry policy requires a producer")
+	}
+	if _, err := cfg.RebalanceStrategy.balanceStrategy(); err != nil {
+		return err
+	}
+	if cfg.Backpressure != nil {
+		if err := cfg.Backpressure.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newSaramaConsumerConfig translates a ConsumerConfig into a sarama config
+func newSaramaConsumerConfig(cfg ConsumerConfig) (*sarama.Config, error) {
+	strategy, err := cfg.RebalanceStrategy.balanceStrategy()
+	if err != nil {
+		return nil, err
+	}
+
 	config := sarama.NewConfig()
 	config.ClientID = cfg.ClientID
-	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{strategy}
 	config.Consumer.Offsets.Initial = sarama.OffsetNewest
 	config.Consumer.Return.Errors = true
+	if cfg.InstanceID != "" {
+		// Static membership needs JoinGroup v5
+		config.Consumer.Group.InstanceId = cfg.InstanceID
+		if !config.Version.IsAtLeast(sarama.V2_3_0_0) {
+			config.Version = sarama.V2_3_0_0
+		}
+	}
 
-	client, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID, config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	if err := cfg.Security.apply(config); err != nil {
+		return nil, fmt.Errorf("invalid security config: %w", err)
 	}
+	return config, nil
+}
 
-	return &Consumer{
-		client:  client,
-		handler: handler,
-		logger:  logger,
-		tracer:  otel.Tracer("banking-shared/kafka"),
-		topics:  cfg.Topics,
-		ready:   make(chan bool),
-	}, nil
+// newConsumer wraps a consumer group client
+func newConsumer(cfg ConsumerConfig, client sarama.ConsumerGroup, handler MessageHandler, metrics *consumerMetrics, logger *zap.Logger) *Consumer {
+	c := &Consumer{
+		client:     client,
+		handler:    handler,
+		logger:     logger,
+		tracer:     otel.Tracer("banking-shared/kafka"),
+		topics:     cfg.Topics,
+		groupID:    cfg.GroupID,
+		deadLetter: cfg.DeadLetter,
+		retry:      cfg.Retry,
+		workers:    cfg.Concurrency,
+		metrics:    metrics,
+		onAssigned: cfg.OnAssigned,
+		onRevoked:  cfg.OnRevoked,
+		replayIdle: cfg.ReplayIdleTimeout,
+	}
+	if c.replayIdle <= 0 {
+		c.replayIdle = defaultReplayIdleTimeout
+	}
+	if cfg.Backpressure != nil {
+		c.backpressure = c.newConsumerBackpressure(*cfg.Backpressure)
+	}
+	return c
 }
 
-// Start begins consuming messages
+// Start begins consuming messages and returns once the first session has
+// started, or with the context's error if ctx ends first. A Consumer can only
+// be started once: Stop closes the group client, so later calls return
+// ErrConsumerStarted and a new Consumer is needed to consume again.
 func (c *Consumer) Start(ctx context.Context) error {
+	if !c.started.CompareAndSwap(false, true) {
+		return ErrConsumerStarted
+	}
 	ctx, cancel := context.WithCancel(ctx)
 	c.cancel = cancel
+	c.health.setState(ConsumerStateJoining)
+	c.ready = make(chan struct{})
+
+	// Errors are returned on a channel because Return.Errors is set; it must be drained
+	c.errorsDone = make(chan struct{})
+	go func() {
+		defer close(c.errorsDone)
+		c.drainErrors(c.client.Errors())
+	}()
 
 	c.wg.Add(1)
 	go func() {
@@ -70,17 +279,21 @@ func (c *Consumer) Start(ctx context.Context) error {
 		for {
 			if err := c.client.Consume(ctx, c.topics, c); err != nil {
 				c.logger.Error("Consumer error", zap.Error(err))
+				c.health.recordError(err)
 			}
 			if ctx.Err() != nil {
 				return
 			}
-			c.ready = make(chan bool)
 		}
 	}()
 
-	<-c.ready
-	c.logger.Info("Consumer started", zap.Strings("topics", c.topics))
-	return nil
+	select {
+	case <-c.ready:
+		c.logger.Info("Consumer started", zap.Strings("topics", c.topics))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // Stop stops the consumer gracefully
@@ -89,55 +302,241 @@ func (c *Consumer) Stop() error {
 		c.cancel()
 	}
 	c.wg.Wait()
-	return c.client.Close()
+	c.backpressure.stop()
+	if merr := c.metrics.unregister(); merr != nil {
+		c.logger.Error("Failed to unregister consumer metrics", zap.Error(merr))
+	}
+	err := c.client.Close()
+	if c.errorsDone != nil {
+		<-c.errorsDone
+	}
+	if c.txn != nil {
+		if perr := c.txn.producer.Close(); perr != nil && err == nil {
+			err = perr
+		}
+	}
+	c.health.setState(ConsumerStateStopped)
+	return err
 }
 
 // Setup is run at the beginning of a new session
-func (c *Consumer) Setup(sarama.ConsumerGroupSession) error {
-	close(c.ready)
+func (c *Consumer) Setup(session sarama.ConsumerGroupSession) error {
+	c.health.sessionStarted(session)
+	c.readyOnce.Do(func() { close(c.ready) })
 	return nil
 }
 
 // Cleanup is run at the end of a session
 func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
+	c.health.setState(ConsumerStateJoining)
 	return nil
 }
 
-// ConsumeClaim processes messages from a partition
+// ConsumeClaim processes messages from a partition, calling the assignment
+// callbacks around the claim
 func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	assignment := PartitionAssignment{
+		Topic:         claim.Topic(),
+		Partition:     claim.Partition(),
+		Generation:    session.GenerationID(),
+		InitialOffset: claim.InitialOffset(),
+	}
+	if c.onAssigned != nil {
+		if err := c.onAssigned(session.Context(), assignment); err != nil {
+			return fmt.Errorf("failed to handle assignment of %s/%d: %w", assignment.Topic, assignment.Partition, err)
+		}
+	}
+	c.reapplyPause(assignment.Topic, assignment.Partition)
+
+	err := c.consumeClaim(session, claim)
+
+	if c.onRevoked != nil {
+		// The session context is canceled on rebalance, but state may still need flushing
+		if rerr := c.onRevoked(context.WithoutCancel(session.Context()), assignment); rerr != nil && err == nil {
+			err = fmt.Errorf("failed to handle revocation of %s/%d: %w", assignment.Topic, assignment.Partition, rerr)
+		}
+	}
+	return err
+}
+
+// consumeClaim processes messages from a partition until the claim ends
+func (c *Consumer) consumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	lag := c.metrics.track(claim)
+	defer c.metrics.untrack(lag)
+	session = lag.wrap(session)
+
+	if c.txn != nil {
+		return c.consumeTransactional(session, claim, lag)
+	}
+	if c.batch != nil {
+		return c.consumeBatches(session, claim)
+	}
+	if c.workers > 1 {
+		return c.consumeConcurrently(session, claim)
+	}
+
 	for {
 		select {
 		case message, ok := <-claim.Messages():
 			if !ok {
 				return nil
 			}
-
-			ctx, span := c.tracer.Start(context.Background(), "kafka.consume",
-				trace.WithAttributes(
-					attribute.String("kafka.topic", message.Topic),
-					attribute.Int64("kafka.partition", int64(message.Partition)),
-					attribute.Int64("kafka.offset", message.Offset),
-				),
-			)
-
-			if err := c.handler(ctx, message); err != nil {
-				span.RecordError(err)
-				c.logger.Error("Failed to process message",
-					zap.String("topic", message.Topic),
-					zap.Int32("partition", message.Partition),
-					zap.Int64("offset", message.Offset),
-					zap.Error(err),
-				)
-				// Don't commit on error - message will be reprocessed
-				span.End()
-				continue
+			done, err := c.process(session.Context(), message)
+			if err != nil {
+				return err
+			}
+			if !done {
+				if session.Context().Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("failed to process message from %s/%d@%d", message.Topic, message.Partition, message.Offset)
 			}
-
 			session.MarkMessage(message, "")
-			span.End()
 
 		case <-session.Context().Done():
 			return nil
 		}
 	}
 }
+
+// process runs the handler for a single message, retrying and dead-lettering
+// according to the consumer's policy. It reports whether the message is done
+// and may be committed; sessionCtx ends retries early on rebalance. A returned
+// error ends the session so the message is redelivered from the last committed offset.
+func (c *Consumer) process(sessionCtx context.Context, message *sarama.ConsumerMessage) (bool, error) {
+	return c.processWith(sessionCtx, message, c.handler)
+}
+
+// processWith is process with an explicit handler
+func (c *Consumer) processWith(sessionCtx context.Context, message *sarama.ConsumerMessage, handler MessageHandler) (bool, error) {
+	c.health.messageReceived()
+
+	if c.delayed {
+		if due, ok := notBefore(message); ok && !sleepContext(sessionCtx, time.Until(due)) {
+			// Session is ending before the retry is due; it will be redelivered
+			return false, nil
+		}
+	}
+
+	c.backpressure.begin()
+	defer c.backpressure.end()
+
+	ctx, span := c.startConsumeSpan(sessionCtx, message)
+	defer span.End()
+	ctx = contextWithMessageCorrelation(ctx, message)
+
+	maxAttempts := c.deadLetter.attempts()
+	var err error
+	attempts := 0
+	for attempts < maxAttempts {
+		attempts++
+		start := time.Now()
+		err = callHandler(ctx, handler, message, c.logger)
+		c.metrics.recordDelivery(ctx, message, start, err)
+		if err == nil {
+			c.backpressure.record(nil)
+			return true, nil
+		}
+		if IsNonRetryable(err) {
+			break
+		}
+		if attempts < maxAttempts && !sleepContext(sessionCtx, c.deadLetter.Backoff) {
+			// Session is ending; the next owner of the partition will retry
+			return false, nil
+		}
+	}
+
+	span.RecordError(err)
+	if sessionCtx.Err() != nil {
+		// The failure may be the session ending; the next owner of the
+		// partition retries instead of the message being dead-lettered
+		return false, nil
+	}
+	// The error rate counts messages, not the attempts spent on them
+	c.backpressure.record(err)
+	c.logger.Error("Failed to process message",
+		zap.String("topic", message.Topic),
+		zap.Int32("partition", message.Partition),
+		zap.Int64("offset", message.Offset),
+		zap.Int("attempts", attempts),
+		zap.Bool("retryable", !IsNonRetryable(err)),
+		zap.Error(err),
+	)
+
+	// Attempts accumulate across retry tiers
+	attempts += headerInt(message, HeaderRetryAttempts)
+
+	if tier, ok := c.retry.next(message); ok && !IsNonRetryable(err) {
+		if retryErr := c.retry.publish(ctx, message, tier, err, attempts); retryErr != nil {
+			span.RecordError(retryErr)
+			return false, fmt.Errorf("failed to schedule retry for message from %s/%d@%d: %w",
+				message.Topic, message.Partition, message.Offset, retryErr)
+		}
+		c.logger.Warn("Message scheduled for retry",
+			zap.String("topic", message.Topic),
+			zap.Int32("partition", message.Partition),
+			zap.Int64("offset", message.Offset),
+			zap.Int("tier", tier),
+		)
+		return true, nil
+	}
+
+	if c.deadLetter == nil {
+		// Don't commit on error - message will be reprocessed
+		return false, nil
+	}
+
+	if dlqErr := c.deadLetter.publish(ctx, c.groupID, message, err, attempts); dlqErr != nil {
+		span.RecordError(dlqErr)
+		return false, fmt.Errorf("failed to dead-letter message from %s/%d@%d: %w",
+			message.Topic, message.Partition, message.Offset, dlqErr)
+	}
+
+	c.logger.Warn("Message dead-lettered",
+		zap.String("topic", message.Topic),
+		zap.Int("attempts", attempts),
+		zap.Int32("partition", message.Partition),
+		zap.Int64("offset", message.Offset),
+	)
+	return true, nil
+}
+
+// startConsumeSpan starts a consumer span that continues the trace propagated
+// in the message headers. The span's context is derived from sessionCtx, so
+// handlers are cancelled when the session ends on rebalance or Stop.
+func (c *Consumer) startConsumeSpan(sessionCtx context.Context, message *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	ctx := propagator().Extract(sessionCtx, consumerCarrier{message})
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKafka,
+		semconv.MessagingOperationDeliver,
+		semconv.MessagingDestinationName(message.Topic),
+		semconv.MessagingKafkaDestinationPartition(int(message.Partition)),
+		semconv.MessagingKafkaMessageOffset(int(message.Offset)),
+		semconv.MessagingKafkaConsumerGroup(c.groupID),
+		semconv.MessagingMessageBodySize(len(message.Value)),
+	}
+	if message.Key != nil {
+		attrs = append(attrs, semconv.MessagingKafkaMessageKey(string(message.Key)))
+	}
+
+	return c.tracer.Start(ctx, "kafka.consume",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// sleepContext waits for d or until ctx is done; it reports whether the full delay elapsed
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
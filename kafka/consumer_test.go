@@ -0,0 +1,282 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakeSession records marked offsets for a consumer group session
+type fakeSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+	mu     sync.Mutex
+	marked []*sarama.ConsumerMessage
+}
+
+func newFakeSession(ctx context.Context) *fakeSession {
+	return &fakeSession{ctx: ctx}
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return s.claims }
+func (s *fakeSession) MemberID() string           { return "member-1" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, &sarama.ConsumerMessage{Topic: topic, Partition: partition, Offset: offset - 1})
+}
+func (s *fakeSession) Commit() {}
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg)
+}
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+func (s *fakeSession) markedOffsets() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offsets := make([]int64, 0, len(s.marked))
+	for _, m := range s.marked {
+		offsets = append(offsets, m.Offset)
+	}
+	return offsets
+}
+
+// fakeClaim serves a fixed set of messages for one partition
+type fakeClaim struct {
+	topic     string
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(topic string, partition int32, msgs ...*sarama.ConsumerMessage) *fakeClaim {
+	ch := make(chan *sarama.ConsumerMessage, len(msgs))
+	for _, m := range msgs {
+		ch <- m
+	}
+	close(ch)
+	return &fakeClaim{topic: topic, partition: partition, messages: ch}
+}
+
+func (c *fakeClaim) Topic() string                            { return c.topic }
+func (c *fakeClaim) Partition() int32                         { return c.partition }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return int64(cap(c.messages)) }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func newTestProducer(t *testing.T) (*Producer, *mocks.SyncProducer) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	mockProducer := mocks.NewSyncProducer(t, config)
+
+	return &Producer{
+		producer: mockProducer,
+		cb:       gobreaker.NewCircuitBreaker(gobreaker.Settings{Name: "kafka-producer-test"}),
+		logger:   zaptest.NewLogger(t),
+		tracer:   otel.Tracer("test"),
+	}, mockProducer
+}
+
+func newTestConsumer(t *testing.T, handler MessageHandler, dlq *DeadLetterPolicy) *Consumer {
+	return &Consumer{
+		handler:    handler,
+		logger:     zaptest.NewLogger(t),
+		tracer:     otel.Tracer("test"),
+		groupID:    "test-group",
+		deadLetter: dlq,
+	}
+}
+
+func testMessage(offset int64) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     "banking.transactions.initiated",
+		Partition: 2,
+		Offset:    offset,
+		Key:       []byte("user-1"),
+		Value:     []byte(`{"event_type":"TransactionInitiated"}`),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("content-type"), Value: []byte("application/json")},
+		},
+	}
+}
+
+func headerMap(headers []sarama.RecordHeader) map[string]string {
+	m := make(map[string]string, len(headers))
+	for _, h := range headers {
+		m[string(h.Key)] = string(h.Value)
+	}
+	return m
+}
+
+func TestConsumer_ConsumeClaim_Success(t *testing.T) {
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return nil
+	}, nil)
+	session := newFakeSession(context.Background())
+
+	err := c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(1), testMessage(2)))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, session.markedOffsets())
+}
+
+func TestConsumer_ConsumeClaim_NoDeadLetter(t *testing.T) {
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return errors.New("boom")
+	}, nil)
+	session := newFakeSession(context.Background())
+
+	err := c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(1)))
+	require.Error(t, err)
+	assert.Empty(t, session.markedOffsets())
+}
+
+func TestConsumer_ConsumeClaim_NoDeadLetterStopsAtFailure(t *testing.T) {
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 2 {
+			return errors.New("boom")
+		}
+		return nil
+	}, nil)
+	session := newFakeSession(context.Background())
+
+	err := c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(1), testMessage(2), testMessage(3)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "banking.transactions.initiated/2@2")
+	assert.Equal(t, []int64{1}, session.markedOffsets())
+}
+
+func TestConsumer_ConsumeClaim_DeadLetter(t *testing.T) {
+	producer, mockProducer := newTestProducer(t)
+	policy := DefaultDeadLetterPolicy(producer)
+	policy.Backoff = 0
+
+	calls := 0
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		calls++
+		return errors.New("insufficient funds")
+	}, policy)
+	session := newFakeSession(context.Background())
+
+	var published *sarama.ProducerMessage
+	mockProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		published = msg
+		return nil
+	})
+
+	err := c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(7)))
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []int64{7}, session.markedOffsets())
+
+	require.NotNil(t, published)
+	assert.Equal(t, "banking.transactions.initiated.dlq", published.Topic)
+	key, _ := published.Key.Encode()
+	assert.Equal(t, "user-1", string(key))
+
+	headers := headerMap(published.Headers)
+	assert.Equal(t, "application/json", headers["content-type"])
+	assert.Equal(t, "insufficient funds", headers[HeaderDLQError])
+	assert.Equal(t, "3", headers[HeaderDLQAttempts])
+	assert.Equal(t, "banking.transactions.initiated", headers[HeaderDLQOriginalTopic])
+	assert.Equal(t, "2", headers[HeaderDLQOriginalPartition])
+	assert.Equal(t, "7", headers[HeaderDLQOriginalOffset])
+	assert.Equal(t, "test-group", headers[HeaderDLQConsumerGroup])
+}
+
+func TestConsumer_ConsumeClaim_DeadLetterPublishFails(t *testing.T) {
+	producer, mockProducer := newTestProducer(t)
+	policy := DefaultDeadLetterPolicy(producer)
+	policy.MaxAttempts = 1
+
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return errors.New("boom")
+	}, policy)
+	session := newFakeSession(context.Background())
+
+	mockProducer.ExpectSendMessageAndFail(errors.New("broker down"))
+
+	err := c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(3)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broker down")
+	assert.Empty(t, session.markedOffsets())
+}
+
+func TestConsumer_ConsumeClaim_AssignmentCallbacks(t *testing.T) {
+	var events []string
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		events = append(events, fmt.Sprintf("message %d", msg.Offset))
+		return nil
+	}, nil)
+	c.onAssigned = func(ctx context.Context, a PartitionAssignment) error {
+		assert.Equal(t, PartitionAssignment{Topic: "banking.transactions.initiated", Partition: 2, Generation: 1}, a)
+		events = append(events, "assigned")
+		return nil
+	}
+	c.onRevoked = func(ctx context.Context, a PartitionAssignment) error {
+		assert.NoError(t, ctx.Err(), "revocation context outlives the session")
+		events = append(events, "revoked")
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	session := newFakeSession(ctx)
+	claim := &fakeClaim{topic: "banking.transactions.initiated", partition: 2, messages: make(chan *sarama.ConsumerMessage)}
+
+	require.NoError(t, c.ConsumeClaim(session, claim))
+	require.NoError(t, c.ConsumeClaim(newFakeSession(context.Background()), newFakeClaim("banking.transactions.initiated", 2, testMessage(1))))
+	assert.Equal(t, []string{"assigned", "revoked", "assigned", "message 1", "revoked"}, events)
+}
+
+func TestConsumer_ConsumeClaim_AssignmentCallbackErrors(t *testing.T) {
+	calls := 0
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		calls++
+		return nil
+	}, nil)
+	c.onAssigned = func(context.Context, PartitionAssignment) error { return errors.New("cache unavailable") }
+
+	err := c.ConsumeClaim(newFakeSession(context.Background()), newFakeClaim("banking.transactions.initiated", 2, testMessage(1)))
+	require.ErrorContains(t, err, "cache unavailable")
+	assert.Zero(t, calls, "messages are not handled when assignment fails")
+
+	c.onAssigned = nil
+	c.onRevoked = func(context.Context, PartitionAssignment) error { return errors.New("flush failed") }
+	session := newFakeSession(context.Background())
+	err = c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(1)))
+	require.ErrorContains(t, err, "flush failed")
+	assert.Equal(t, []int64{1}, session.markedOffsets())
+}
+
+func TestNewSaramaConsumerConfig_Rebalance(t *testing.T) {
+	config, err := newSaramaConsumerConfig(ConsumerConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, sarama.RoundRobinBalanceStrategyName, config.Consumer.Group.Rebalance.GroupStrategies[0].Name())
+	assert.Empty(t, config.Consumer.Group.InstanceId)
+
+	config, err = newSaramaConsumerConfig(ConsumerConfig{RebalanceStrategy: RebalanceSticky, InstanceID: "fraud-service-0"})
+	require.NoError(t, err)
+	assert.Equal(t, sarama.StickyBalanceStrategyName, config.Consumer.Group.Rebalance.GroupStrategies[0].Name())
+	assert.Equal(t, "fraud-service-0", config.Consumer.Group.InstanceId)
+	assert.True(t, config.Version.IsAtLeast(sarama.V2_3_0_0))
+	require.NoError(t, config.Validate())
+
+	_, err = newSaramaConsumerConfig(ConsumerConfig{RebalanceStrategy: RebalanceCooperativeSticky})
+	assert.ErrorContains(t, err, "not supported")
+	assert.Error(t, ConsumerConfig{RebalanceStrategy: "unknown"}.validate())
+}
@@ -0,0 +1,289 @@
+package kafka
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// DedupStore records which events each consumer group has processed successfully
+type DedupStore interface {
+	// Seen reports whether the event was already processed by the group
+	Seen(ctx context.Context, group, eventID string) (bool, error)
+	// MarkProcessed records that the event was processed by the group
+	MarkProcessed(ctx context.Context, group, eventID string) error
+}
+
+// TxDedupStore is a DedupStore whose processed record can share a database
+// transaction with the handler, making it atomic with the handler's success
+type TxDedupStore interface {
+	DedupStore
+	// BeginTx starts the transaction shared by the handler and the processed record
+	BeginTx(ctx context.Context) (*sql.Tx, error)
+	// RecordTx records the event inside tx; it reports false if the event was already recorded
+	RecordTx(ctx context.Context, tx *sql.Tx, group, eventID string) (bool, error)
+}
+
+type txContextKey struct{}
+
+// TxFromContext returns the transaction opened by the Dedup middleware for a
+// TxDedupStore. Handlers must write through it for their changes to be atomic
+// with the processed record.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txContextKey{}).(*sql.Tx)
+	return tx, ok
+}
+
+// Dedup returns consumer middleware that skips messages whose BaseEvent.EventID
+// was already processed successfully by groupID. Messages without an event ID
+// are passed through. With a TxDedupStore the handler runs inside the store's
+// transaction (see TxFromContext) and the processed record commits with it.
+func Dedup(store DedupStore, groupID string, logger *zap.Logger) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			eventID := messageEventID(msg)
+			if eventID == "" {
+				return next(ctx, msg)
+			}
+
+			if txStore, ok := store.(TxDedupStore); ok {
+				return dedupTx(ctx, txStore, groupID, eventID, msg, next, logger)
+			}
+
+			seen, err := store.Seen(ctx, groupID, eventID)
+			if err != nil {
+				return fmt.Errorf("failed to check processed events: %w", err)
+			}
+			if seen {
+				logDuplicate(logger, msg, eventID)
+				return nil
+			}
+
+			if err := next(ctx, msg); err != nil {
+				return err
+			}
+
+			if err := store.MarkProcessed(ctx, groupID, eventID); err != nil {
+				return fmt.Errorf("failed to record processed event: %w", err)
+			}
+			return nil
+		}
+	}
+}
+
+// dedupTx claims the event and runs the handler in a single transaction
+func dedupTx(ctx context.Context, store TxDedupStore, groupID, eventID string, msg *sarama.ConsumerMessage, next MessageHandler, logger *zap.Logger) error {
+	tx, err := store.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin dedup transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	recorded, err := store.RecordTx(ctx, tx, groupID, eventID)
+	if err != nil {
+		return fmt.Errorf("failed to record processed event: %w", err)
+	}
+	if !recorded {
+		logDuplicate(logger, msg, eventID)
+		return nil
+	}
+
+	if err := next(context.WithValue(ctx, txContextKey{}, tx), msg); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit dedup transaction: %w", err)
+	}
+	return nil
+}
+
+func logDuplicate(logger *zap.Logger, msg *sarama.ConsumerMessage, eventID string) {
+	logger.Info("Skipping already processed event",
+		zap.String("event_id", eventID),
+		zap.String("topic", msg.Topic),
+		zap.Int32("partition", msg.Partition),
+		zap.Int64("offset", msg.Offset),
+	)
+}
+
+// messageEventID extracts BaseEvent.EventID from a message payload
+func messageEventID(msg *sarama.ConsumerMessage) string {
+	var envelope struct {
+		EventID uuid.UUID `json:"event_id"`
+	}
+	if err := json.Unmarshal(msg.Value, &envelope); err != nil || envelope.EventID == uuid.Nil {
+		return ""
+	}
+	return envelope.EventID.String()
+}
+
+// MemoryDedupStore is an in-memory DedupStore bounded by size (LRU) and age (TTL).
+// It only deduplicates within a single process.
+type MemoryDedupStore struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List // front is most recently processed
+	now      func() time.Time
+}
+
+type dedupEntry struct {
+	key         string
+	processedAt time.Time
+}
+
+// NewMemoryDedupStore creates an in-memory store holding at most capacity
+// events, each for at most ttl (zero disables expiry)
+func NewMemoryDedupStore(capacity int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Seen reports whether the event was processed by the group and has not expired
+func (s *MemoryDedupStore) Seen(_ context.Context, group, eventID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[group+"/"+eventID]
+	if !ok {
+		return false, nil
+	}
+	if s.expired(el.Value.(*dedupEntry)) {
+		s.remove(el)
+		return false, nil
+	}
+	return true, nil
+}
+
+// MarkProcessed records the event, evicting the least recently processed entry when full
+func (s *MemoryDedupStore) MarkProcessed(_ context.Context, group, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := group + "/" + eventID
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*dedupEntry).processedAt = s.now()
+		s.order.MoveToFront(el)
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&dedupEntry{key: key, processedAt: s.now()})
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// Len returns the number of events currently held
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryDedupStore) expired(e *dedupEntry) bool {
+	return s.ttl > 0 && s.now().Sub(e.processedAt) > s.ttl
+}
+
+func (s *MemoryDedupStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*dedupEntry).key)
+}
+
+// tableNameRegex restricts table names interpolated into SQL statements
+var tableNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// SQLDedupStore is a TxDedupStore backed by a database/sql table with a
+// primary key on (consumer_group, event_id). Queries use $N placeholders and
+// ON CONFLICT, as supported by PostgreSQL and SQLite.
+type SQLDedupStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLDedupStore creates a store using the given table (see CreateTable)
+func NewSQLDedupStore(db *sql.DB, table string) (*SQLDedupStore, error) {
+	if !tableNameRegex.MatchString(table) {
+		return nil, fmt.Errorf("invalid dedup table name %q", table)
+	}
+	return &SQLDedupStore{db: db, table: table}, nil
+}
+
+// CreateTable creates the processed events table if it does not exist
+func (s *SQLDedupStore) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		consumer_group TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		processed_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (consumer_group, event_id)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create dedup table: %w", err)
+	}
+	return nil
+}
+
+// Seen reports whether the event was processed by the group
+func (s *SQLDedupStore) Seen(ctx context.Context, group, eventID string) (bool, error) {
+	var one int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT 1 FROM `+s.table+` WHERE consumer_group = $1 AND event_id = $2`,
+		group, eventID,
+	).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MarkProcessed records the event outside of any handler transaction
+func (s *SQLDedupStore) MarkProcessed(ctx context.Context, group, eventID string) error {
+	_, err := s.db.ExecContext(ctx, s.insertQuery(), group, eventID, time.Now().UTC())
+	return err
+}
+
+// BeginTx starts the transaction shared by the handler and the processed record
+func (s *SQLDedupStore) BeginTx(ctx context.Context) (*sql.Tx, error) {
+	return s.db.BeginTx(ctx, nil)
+}
+
+// RecordTx inserts the processed record inside tx. A concurrent insert of the
+// same event blocks on the primary key until the other transaction finishes,
+// so at most one handler commits per event.
+func (s *SQLDedupStore) RecordTx(ctx context.Context, tx *sql.Tx, group, eventID string) (bool, error) {
+	res, err := tx.ExecContext(ctx, s.insertQuery(), group, eventID, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *SQLDedupStore) insertQuery() string {
+	return `INSERT INTO ` + s.table + ` (consumer_group, event_id, processed_at) VALUES ($1, $2, $3)
+		ON CONFLICT (consumer_group, event_id) DO NOTHING`
+}
@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func eventMessage(eventID uuid.UUID) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic: "banking.transactions.initiated",
+		Value: []byte(`{"event_id":"` + eventID.String() + `","event_type":"TransactionInitiated"}`),
+	}
+}
+
+func TestMemoryDedupStore_LRU(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(2, 0)
+
+	require.NoError(t, store.MarkProcessed(ctx, "g", "a"))
+	require.NoError(t, store.MarkProcessed(ctx, "g", "b"))
+	require.NoError(t, store.MarkProcessed(ctx, "g", "a"))
+	require.NoError(t, store.MarkProcessed(ctx, "g", "c"))
+
+	seen, _ := store.Seen(ctx, "g", "a")
+	assert.True(t, seen)
+	seen, _ = store.Seen(ctx, "g", "b")
+	assert.False(t, seen, "least recently processed entry should be evicted")
+	seen, _ = store.Seen(ctx, "other-group", "a")
+	assert.False(t, seen, "events are tracked per consumer group")
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestMemoryDedupStore_TTL(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryDedupStore(10, time.Minute)
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.MarkProcessed(ctx, "g", "a"))
+	seen, _ := store.Seen(ctx, "g", "a")
+	assert.True(t, seen)
+
+	now = now.Add(2 * time.Minute)
+	seen, _ = store.Seen(ctx, "g", "a")
+	assert.False(t, seen)
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestDedup_SkipsProcessedEvents(t *testing.T) {
+	store := NewMemoryDedupStore(100, time.Hour)
+	calls := 0
+	handler := Dedup(store, "ledger", zaptest.NewLogger(t))(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		calls++
+		if calls == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	msg := eventMessage(uuid.New())
+	assert.Error(t, handler(context.Background(), msg))
+	assert.NoError(t, handler(context.Background(), msg))
+	assert.NoError(t, handler(context.Background(), msg))
+	assert.Equal(t, 2, calls, "failed attempts must not be recorded as processed")
+
+	// Messages without an event ID always reach the handler
+	assert.NoError(t, handler(context.Background(), &sarama.ConsumerMessage{Value: []byte(`{}`)}))
+	assert.Equal(t, 3, calls)
+}
+
+func TestSQLDedupStore_TransactionalHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewSQLDedupStore(db, "processed_events")
+	require.NoError(t, err)
+
+	eventID := uuid.New()
+	handler := Dedup(store, "ledger", zaptest.NewLogger(t))(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		tx, ok := TxFromContext(ctx)
+		require.True(t, ok)
+		_, err := tx.ExecContext(ctx, "UPDATE accounts SET balance = balance - 10")
+		return err
+	})
+
+	// First delivery: record and handler write commit together
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO processed_events").
+		WithArgs("ledger", eventID.String(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Redelivery: the record already exists, so the handler is skipped
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO processed_events").
+		WithArgs("ledger", eventID.String(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	require.NoError(t, handler(context.Background(), eventMessage(eventID)))
+	require.NoError(t, handler(context.Background(), eventMessage(eventID)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLDedupStore_HandlerFailureRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewSQLDedupStore(db, "processed_events")
+	require.NoError(t, err)
+
+	handler := Dedup(store, "ledger", zaptest.NewLogger(t))(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return errors.New("core banking unavailable")
+	})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO processed_events").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	assert.Error(t, handler(context.Background(), eventMessage(uuid.New())))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNewSQLDedupStore_RejectsInvalidTable(t *testing.T) {
+	_, err := NewSQLDedupStore(nil, "events; DROP TABLE users")
+	assert.Error(t, err)
+}
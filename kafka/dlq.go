@@ -0,0 +1,86 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers stamped on dead-lettered messages
+const (
+	HeaderDLQError             = "dlq-error"
+	HeaderDLQAttempts          = "dlq-attempts"
+	HeaderDLQOriginalTopic     = "dlq-original-topic"
+	HeaderDLQOriginalPartition = "dlq-original-partition"
+	HeaderDLQOriginalOffset    = "dlq-original-offset"
+	HeaderDLQConsumerGroup     = "dlq-consumer-group"
+	HeaderDLQFailedAt          = "dlq-failed-at"
+)
+
+// DefaultDLQSuffix is appended to the source topic to name its dead-letter topic
+const DefaultDLQSuffix = ".dlq"
+
+// DeadLetterPolicy controls how messages that keep failing are dead-lettered
+type DeadLetterPolicy struct {
+	// Producer publishes dead-lettered messages (circuit-broken and traced)
+	Producer *Producer
+	// MaxAttempts is the number of handler attempts before a message is dead-lettered
+	MaxAttempts int
+	// Backoff is the delay between handler attempts
+	Backoff time.Duration
+	// TopicSuffix is appended to the source topic; defaults to DefaultDLQSuffix
+	TopicSuffix string
+}
+
+// DefaultDeadLetterPolicy returns sensible defaults for banking consumers
+func DefaultDeadLetterPolicy(producer *Producer) *DeadLetterPolicy {
+	return &DeadLetterPolicy{
+		Producer:    producer,
+		MaxAttempts: 3,
+		Backoff:     time.Second,
+		TopicSuffix: DefaultDLQSuffix,
+	}
+}
+
+// attempts returns the number of handler attempts, at least one
+func (p *DeadLetterPolicy) attempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Topic returns the dead-letter topic for a source topic
+func (p *DeadLetterPolicy) Topic(topic string) string {
+	if p.TopicSuffix == "" {
+		return topic + DefaultDLQSuffix
+	}
+	return topic + p.TopicSuffix
+}
+
+// publish republishes the original message with failure metadata to the dead-letter topic
+func (p *DeadLetterPolicy) publish(ctx context.Context, groupID string, msg *sarama.ConsumerMessage, cause error, attempts int) error {
+	topic, partition, offset := origin(msg)
+
+	headers := copyHeaders(msg.Headers)
+	headers = setHeader(headers, HeaderDLQError, cause.Error())
+	headers = setHeader(headers, HeaderDLQAttempts, strconv.Itoa(attempts))
+	headers = setHeader(headers, HeaderDLQOriginalTopic, topic)
+	headers = setHeader(headers, HeaderDLQOriginalPartition, strconv.FormatInt(int64(partition), 10))
+	headers = setHeader(headers, HeaderDLQOriginalOffset, strconv.FormatInt(offset, 10))
+	headers = setHeader(headers, HeaderDLQConsumerGroup, groupID)
+	headers = setHeader(headers, HeaderDLQFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+
+	out := &sarama.ProducerMessage{
+		Topic:   p.Topic(topic),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		out.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	return p.Producer.PublishMessage(ctx, out)
+}
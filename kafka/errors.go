@@ -0,0 +1,31 @@
+package kafka
+
+import "errors"
+
+// nonRetryableError marks a handler error that will fail on every attempt
+type nonRetryableError struct {
+	err error
+}
+
+func (e *nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nonRetryableError) Unwrap() error {
+	return e.err
+}
+
+// NonRetryable wraps err so the consumer skips in-process retries and retry
+// tiers and dead-letters the message immediately (e.g. malformed payloads)
+func NonRetryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &nonRetryableError{err: err}
+}
+
+// IsNonRetryable reports whether err, or any error it wraps, is non-retryable
+func IsNonRetryable(err error) bool {
+	var target *nonRetryableError
+	return errors.As(err, &target)
+}
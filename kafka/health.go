@@ -0,0 +1,157 @@
+package kafka
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+)
+
+// ConsumerState is the lifecycle state of a Consumer
+type ConsumerState string
+
+const (
+	// ConsumerStateStopped means Start has not been called or Stop has completed
+	ConsumerStateStopped ConsumerState = "stopped"
+	// ConsumerStateJoining means the consumer is joining the group or rebalancing
+	ConsumerStateJoining ConsumerState = "joining"
+	// ConsumerStateActive means the consumer has a session and partition assignments
+	ConsumerStateActive ConsumerState = "active"
+	// ConsumerStateFailed means the consumer stopped after an unrecoverable
+	// error, reported in LastError; it stays failed after Stop
+	ConsumerStateFailed ConsumerState = "failed"
+)
+
+// ConsumerHealth is a snapshot of a consumer's group membership and activity
+type ConsumerHealth struct {
+	State ConsumerState
+	// StateSince is when the consumer entered State; a consumer that stays
+	// joining for long is likely stuck in a rebalance loop
+	StateSince time.Time
+	GroupID    string
+	MemberID   string
+	Generation int32
+	// Partitions are the partitions assigned in the current session, by topic
+	Partitions map[string][]int32
+	// Sessions counts the sessions started since Start; a fast-growing count indicates frequent rebalances
+	Sessions      int
+	LastMessageAt time.Time
+	LastError     error
+	LastErrorAt   time.Time
+}
+
+// Ready reports whether the consumer has an active session
+func (h ConsumerHealth) Ready() bool {
+	return h.State == ConsumerStateActive
+}
+
+// consumerHealth tracks the state reported by Consumer.Health
+type consumerHealth struct {
+	mu     sync.Mutex
+	status ConsumerHealth
+	// lastMessage is updated on every message, so it avoids the mutex
+	lastMessage atomic.Int64
+	failed      bool
+}
+
+// fail records an unrecoverable error; later state changes are ignored
+func (h *consumerHealth) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failed = true
+	h.status.State = ConsumerStateFailed
+	h.status.StateSince = time.Now()
+	h.status.MemberID = ""
+	h.status.Generation = 0
+	h.status.Partitions = nil
+	h.status.LastError = err
+	h.status.LastErrorAt = h.status.StateSince
+}
+
+func (h *consumerHealth) setState(state ConsumerState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failed {
+		return
+	}
+	h.status.State = state
+	h.status.StateSince = time.Now()
+	if state != ConsumerStateActive {
+		h.status.MemberID = ""
+		h.status.Generation = 0
+		h.status.Partitions = nil
+	}
+}
+
+func (h *consumerHealth) sessionStarted(session sarama.ConsumerGroupSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failed {
+		return
+	}
+	h.status.State = ConsumerStateActive
+	h.status.StateSince = time.Now()
+	h.status.MemberID = session.MemberID()
+	h.status.Generation = session.GenerationID()
+	h.status.Partitions = session.Claims()
+	h.status.Sessions++
+}
+
+func (h *consumerHealth) recordError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.LastError = err
+	h.status.LastErrorAt = time.Now()
+}
+
+func (h *consumerHealth) messageReceived() {
+	h.lastMessage.Store(time.Now().UnixNano())
+}
+
+func (h *consumerHealth) snapshot(groupID string) ConsumerHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := h.status
+	status.GroupID = groupID
+	if status.State == "" {
+		status.State = ConsumerStateStopped
+	}
+	if ns := h.lastMessage.Load(); ns != 0 {
+		status.LastMessageAt = time.Unix(0, ns)
+	}
+	if status.Partitions != nil {
+		partitions := make(map[string][]int32, len(status.Partitions))
+		for topic, ps := range status.Partitions {
+			partitions[topic] = append([]int32(nil), ps...)
+		}
+		status.Partitions = partitions
+	}
+	return status
+}
+
+// Health reports the consumer's state, assignments and most recent activity.
+// It is safe to call concurrently, e.g. from a readiness probe.
+func (c *Consumer) Health() ConsumerHealth {
+	return c.health.snapshot(c.groupID)
+}
+
+// fail stops the consumer after an unrecoverable error and reports it
+// through Health
+func (c *Consumer) fail(err error) {
+	c.logger.Error("Consumer failed", zap.Error(err))
+	c.health.fail(err)
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// drainErrors logs errors reported by the consumer group until it is closed
+func (c *Consumer) drainErrors(errs <-chan error) {
+	for err := range errs {
+		c.logger.Error("Consumer group error", zap.Error(err))
+		c.health.recordError(err)
+	}
+}
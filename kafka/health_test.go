@@ -0,0 +1,124 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGroup runs a single session per Consume call until the context is
+// canceled and records pause calls
+type fakeGroup struct {
+	session *fakeSession
+	errs    chan error
+	once    sync.Once
+
+	mu     sync.Mutex
+	pauses []string
+}
+
+func newFakeGroup(claims map[string][]int32) *fakeGroup {
+	return &fakeGroup{
+		session: &fakeSession{ctx: context.Background(), claims: claims},
+		errs:    make(chan error, 1),
+	}
+}
+
+func (g *fakeGroup) Consume(ctx context.Context, _ []string, handler sarama.ConsumerGroupHandler) error {
+	if err := handler.Setup(g.session); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return handler.Cleanup(g.session)
+}
+
+func (g *fakeGroup) Errors() <-chan error { return g.errs }
+
+func (g *fakeGroup) Close() error {
+	g.once.Do(func() { close(g.errs) })
+	return nil
+}
+
+func (g *fakeGroup) Pause(partitions map[string][]int32) {
+	g.record(fmt.Sprintf("pause %v", partitions))
+}
+func (g *fakeGroup) Resume(partitions map[string][]int32) {
+	g.record(fmt.Sprintf("resume %v", partitions))
+}
+func (g *fakeGroup) PauseAll()  { g.record("pause all") }
+func (g *fakeGroup) ResumeAll() { g.record("resume all") }
+
+func (g *fakeGroup) record(call string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pauses = append(g.pauses, call)
+}
+
+// pauseCalls returns and clears the recorded pause calls
+func (g *fakeGroup) pauseCalls() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	calls := g.pauses
+	g.pauses = nil
+	return calls
+}
+
+func TestConsumer_HealthFollowsLifecycle(t *testing.T) {
+	group := newFakeGroup(map[string][]int32{"banking.transactions.initiated": {0, 2}})
+	c := newTestConsumer(t, func(context.Context, *sarama.ConsumerMessage) error { return nil }, nil)
+	c.client = group
+	c.topics = []string{"banking.transactions.initiated"}
+
+	health := c.Health()
+	assert.Equal(t, ConsumerStateStopped, health.State)
+	assert.False(t, health.Ready())
+
+	require.NoError(t, c.Start(context.Background()))
+
+	health = c.Health()
+	assert.True(t, health.Ready())
+	assert.Equal(t, "test-group", health.GroupID)
+	assert.Equal(t, "member-1", health.MemberID)
+	assert.Equal(t, int32(1), health.Generation)
+	assert.Equal(t, map[string][]int32{"banking.transactions.initiated": {0, 2}}, health.Partitions)
+	assert.Equal(t, 1, health.Sessions)
+	assert.True(t, health.LastMessageAt.IsZero())
+
+	_, err := c.process(context.Background(), testMessage(0))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), c.Health().LastMessageAt, time.Second)
+
+	// Errors reported by the group are drained into the health report
+	group.errs <- errors.New("coordinator not available")
+	assert.Eventually(t, func() bool {
+		return c.Health().LastError != nil
+	}, time.Second, 5*time.Millisecond)
+	assert.EqualError(t, c.Health().LastError, "coordinator not available")
+
+	require.NoError(t, c.Stop())
+	health = c.Health()
+	assert.Equal(t, ConsumerStateStopped, health.State)
+	assert.Nil(t, health.Partitions)
+	assert.Empty(t, health.MemberID)
+	assert.NotNil(t, health.LastError, "the last error outlives the session")
+
+	assert.ErrorIs(t, c.Start(context.Background()), ErrConsumerStarted, "a stopped consumer cannot be restarted")
+	assert.Equal(t, ConsumerStateStopped, c.Health().State)
+}
+
+func TestConsumerHealth_SnapshotIsIsolated(t *testing.T) {
+	var h consumerHealth
+	h.sessionStarted(&fakeSession{ctx: context.Background(), claims: map[string][]int32{"topic": {1}}})
+
+	snapshot := h.snapshot("group")
+	snapshot.Partitions["topic"][0] = 7
+
+	assert.Equal(t, []int32{1}, h.snapshot("group").Partitions["topic"])
+}
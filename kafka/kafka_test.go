@@ -10,6 +10,7 @@ import (
 	"github.com/IBM/sarama/mocks"
 	"github.com/sony/gobreaker"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/otel"
 	"go.uber.org/zap/zaptest"
 )
@@ -81,3 +82,157 @@ func TestDefaultProducerConfig(t *testing.T) {
 	assert.Equal(t, sarama.WaitForAll, cfg.RequiredAcks)
 	assert.Equal(t, 100*time.Millisecond, cfg.FlushFrequency)
 }
+
+func TestProducer_PublishBatch(t *testing.T) {
+	p, mockProducer := newTestProducer(t)
+	events := []Event{MockEvent{ID: "1"}, MockEvent{ID: "2"}, MockEvent{ID: "3"}}
+
+	t.Run("Success", func(t *testing.T) {
+		mockProducer.ExpectSendMessageAndSucceed()
+		mockProducer.ExpectSendMessageAndSucceed()
+		mockProducer.ExpectSendMessageAndSucceed()
+
+		result, err := p.PublishBatch(context.Background(), "test-topic", events)
+		assert.NoError(t, err)
+		assert.Len(t, result.Results, 3)
+		assert.Empty(t, result.Failed())
+		for _, res := range result.Results {
+			assert.GreaterOrEqual(t, res.Offset, int64(0))
+		}
+	})
+
+	t.Run("PartialFailure", func(t *testing.T) {
+		mockProducer.ExpectSendMessageAndSucceed()
+		mockProducer.ExpectSendMessageAndFail(errors.New("kafka error"))
+		mockProducer.ExpectSendMessageAndSucceed()
+
+		result, err := p.PublishBatch(context.Background(), "test-topic", events)
+		assert.Error(t, err)
+		assert.NoError(t, result.Results[0].Err)
+		assert.Contains(t, result.Results[1].Err.Error(), "kafka error")
+		assert.Equal(t, []int{1, 2}, result.Failed())
+		assert.Equal(t, []Event{events[1], events[2]}, result.FailedEvents(events))
+	})
+}
+
+// partialSyncProducer mimics sarama's SendMessages, which writes every message
+// it can and reports the rest as ProducerErrors
+type partialSyncProducer struct {
+	sarama.SyncProducer
+	failIndex int
+}
+
+func (p *partialSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errs sarama.ProducerErrors
+	for i, msg := range msgs {
+		if i == p.failIndex {
+			errs = append(errs, &sarama.ProducerError{Msg: msg, Err: sarama.ErrNotLeaderForPartition})
+			continue
+		}
+		msg.Partition = 0
+		msg.Offset = int64(100 + i)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func TestProducer_PublishBatch_ProducerErrors(t *testing.T) {
+	p, _ := newTestProducer(t)
+	p.producer = &partialSyncProducer{failIndex: 1}
+	events := []Event{MockEvent{ID: "1"}, MockEvent{ID: "2"}, MockEvent{ID: "3"}}
+
+	result, err := p.PublishBatch(context.Background(), "test-topic", events)
+	assert.ErrorContains(t, err, "1 of 3 events failed to publish, first at index 1")
+	assert.ErrorIs(t, result.Results[1].Err, sarama.ErrNotLeaderForPartition)
+	assert.Equal(t, int64(100), result.Results[0].Offset)
+	assert.Equal(t, int64(102), result.Results[2].Offset)
+	assert.Equal(t, []int{1}, result.Failed())
+}
+
+func TestProducerConfig_Validate(t *testing.T) {
+	valid := DefaultProducerConfig([]string{"localhost:9092"}, "test-client")
+	require.NoError(t, valid.Validate())
+
+	tests := []struct {
+		name   string
+		modify func(cfg *ProducerConfig)
+	}{
+		{"NoBrokers", func(cfg *ProducerConfig) { cfg.Brokers = nil }},
+		{"IdempotentWithoutAcksAll", func(cfg *ProducerConfig) { cfg.RequiredAcks = sarama.WaitForLocal }},
+		{"IdempotentWithOpenRequests", func(cfg *ProducerConfig) { cfg.MaxOpenRequests = 5 }},
+		{"IdempotentWithoutRetries", func(cfg *ProducerConfig) { cfg.RetryMax = 0 }},
+		{"TransactionalWithoutIdempotence", func(cfg *ProducerConfig) {
+			cfg.DisableIdempotence = true
+			cfg.TransactionalID = "fraud-1"
+		}},
+		{"FailureRatioOutOfRange", func(cfg *ProducerConfig) { cfg.Breaker.FailureRatio = 1.5 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			assert.Error(t, cfg.Validate())
+		})
+	}
+
+	t.Run("NonIdempotent", func(t *testing.T) {
+		cfg := valid
+		cfg.DisableIdempotence = true
+		cfg.RequiredAcks = sarama.WaitForLocal
+		cfg.MaxOpenRequests = 5
+		require.NoError(t, cfg.Validate())
+
+		config, err := newSaramaProducerConfig(cfg)
+		require.NoError(t, err)
+		assert.False(t, config.Producer.Idempotent)
+		assert.Equal(t, 5, config.Net.MaxOpenRequests)
+		require.NoError(t, config.Validate())
+	})
+}
+
+func TestNewProducer_RejectsTransactionalID(t *testing.T) {
+	cfg := DefaultProducerConfig([]string{"localhost:9092"}, "test-client")
+	cfg.TransactionalID = "fraud-1"
+
+	_, err := NewProducer(cfg, zaptest.NewLogger(t))
+	assert.ErrorContains(t, err, "NewTransactionalConsumer")
+	_, err = NewAsyncProducer(cfg, zaptest.NewLogger(t))
+	assert.ErrorContains(t, err, "NewTransactionalConsumer")
+}
+
+func TestNewSaramaProducerConfig_Partitioner(t *testing.T) {
+	cfg := DefaultProducerConfig([]string{"localhost:9092"}, "test-client")
+	config, err := newSaramaProducerConfig(cfg)
+	require.NoError(t, err)
+	assert.True(t, config.Producer.Idempotent)
+	assert.Equal(t, 1, config.Net.MaxOpenRequests)
+	assert.IsType(t, sarama.NewHashPartitioner("t"), config.Producer.Partitioner("t"))
+
+	cfg.Partitioner = sarama.NewRoundRobinPartitioner
+	config, err = newSaramaProducerConfig(cfg)
+	require.NoError(t, err)
+	assert.IsType(t, sarama.NewRoundRobinPartitioner("t"), config.Producer.Partitioner("t"))
+}
+
+func TestNewBreakerSettings(t *testing.T) {
+	var transitions []gobreaker.State
+	cfg := DefaultProducerConfig([]string{"localhost:9092"}, "test-client")
+	cfg.Breaker = BreakerConfig{MinRequests: 2, FailureRatio: 0.5, Timeout: time.Minute}
+	cfg.OnStateChange = func(name string, from, to gobreaker.State) {
+		transitions = append(transitions, to)
+	}
+
+	settings := newBreakerSettings("kafka-producer", cfg, zaptest.NewLogger(t))
+	assert.Equal(t, uint32(3), settings.MaxRequests, "zero fields use defaults")
+	assert.Equal(t, time.Minute, settings.Timeout)
+	assert.False(t, settings.ReadyToTrip(gobreaker.Counts{Requests: 1, TotalFailures: 1}))
+	assert.True(t, settings.ReadyToTrip(gobreaker.Counts{Requests: 2, TotalFailures: 1}))
+
+	cb := gobreaker.NewCircuitBreaker(settings)
+	for i := 0; i < 2; i++ {
+		_, _ = cb.Execute(func() (interface{}, error) { return nil, errors.New("broker down") })
+	}
+	assert.Equal(t, []gobreaker.State{gobreaker.StateOpen}, transitions)
+}
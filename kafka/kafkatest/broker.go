@@ -0,0 +1,258 @@
+// Package kafkatest provides an in-memory Kafka broker for testing services
+// built on the kafka package without a real cluster.
+package kafkatest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// DefaultPartitions is the partition count of topics created on first use
+const DefaultPartitions = 3
+
+// Addr is the placeholder broker address used in configs for the in-memory broker
+const Addr = "kafkatest:9092"
+
+// Broker is an in-memory Kafka broker with topics, keyed partitioning,
+// consumer groups and committed offsets. It is safe for concurrent use.
+type Broker struct {
+	mu         sync.Mutex
+	topics     map[string][][]*sarama.ConsumerMessage
+	groups     map[string]*group
+	produceErr map[string]error
+	next       map[string]int32 // round-robin partition for messages without a key
+	changed    chan struct{}    // closed and replaced on every change
+}
+
+// NewBroker creates an empty broker
+func NewBroker() *Broker {
+	return &Broker{
+		topics:     make(map[string][][]*sarama.ConsumerMessage),
+		groups:     make(map[string]*group),
+		produceErr: make(map[string]error),
+		next:       make(map[string]int32),
+		changed:    make(chan struct{}),
+	}
+}
+
+// CreateTopic creates a topic with the given number of partitions. Topics
+// are otherwise created with DefaultPartitions when first used.
+func (b *Broker) CreateTopic(topic string, partitions int32) error {
+	if partitions < 1 {
+		return fmt.Errorf("topic %s needs at least one partition, got %d", topic, partitions)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.topics[topic]; ok {
+		return fmt.Errorf("topic %s already exists", topic)
+	}
+	b.topics[topic] = make([][]*sarama.ConsumerMessage, partitions)
+	b.notify()
+	return nil
+}
+
+// SetProduceError makes producing to topic fail with err until it is cleared with nil
+func (b *Broker) SetProduceError(topic string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		delete(b.produceErr, topic)
+		return
+	}
+	b.produceErr[topic] = err
+}
+
+// Produce appends msg to its topic and returns the partition and offset it
+// was written to. Messages with a key are partitioned by sarama's hash
+// partitioner; messages without one are spread round-robin.
+func (b *Broker) Produce(msg *sarama.ProducerMessage) (int32, int64, error) {
+	key, err := encode(msg.Key)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to encode key: %w", err)
+	}
+	value, err := encode(msg.Value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to encode value: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.produceErr[msg.Topic]; err != nil {
+		return 0, 0, err
+	}
+
+	partitions := b.topic(msg.Topic)
+	n := int32(len(partitions))
+	var partition int32
+	if key == nil {
+		partition = b.next[msg.Topic] % n
+		b.next[msg.Topic]++
+	} else {
+		partition, err = sarama.NewHashPartitioner(msg.Topic).Partition(msg, n)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to partition message: %w", err)
+		}
+	}
+
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	headers := make([]*sarama.RecordHeader, len(msg.Headers))
+	for i := range msg.Headers {
+		h := msg.Headers[i]
+		headers[i] = &h
+	}
+
+	offset := int64(len(partitions[partition]))
+	partitions[partition] = append(partitions[partition], &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Partition: partition,
+		Offset:    offset,
+		Key:       key,
+		Value:     value,
+		Headers:   headers,
+		Timestamp: timestamp,
+	})
+	msg.Partition = partition
+	msg.Offset = offset
+	b.notify()
+	return partition, offset, nil
+}
+
+func encode(e sarama.Encoder) ([]byte, error) {
+	if e == nil {
+		return nil, nil
+	}
+	return e.Encode()
+}
+
+// Messages returns a copy of every message on topic, ordered by partition and offset
+func (b *Broker) Messages(topic string) []*sarama.ConsumerMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []*sarama.ConsumerMessage
+	for _, partition := range b.topics[topic] {
+		for _, msg := range partition {
+			out = append(out, copyMessage(msg))
+		}
+	}
+	return out
+}
+
+// CommittedOffset returns the next offset group will consume from a
+// partition, or -1 if the group has not committed one
+func (b *Broker) CommittedOffset(groupID, topic string, partition int32) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[groupID]
+	if !ok {
+		return -1
+	}
+	offset, ok := g.committed[topic][partition]
+	if !ok {
+		return -1
+	}
+	return offset
+}
+
+// topic returns the partitions of topic, creating it if needed; b.mu must be held
+func (b *Broker) topic(name string) [][]*sarama.ConsumerMessage {
+	partitions, ok := b.topics[name]
+	if !ok {
+		partitions = make([][]*sarama.ConsumerMessage, DefaultPartitions)
+		b.topics[name] = partitions
+	}
+	return partitions
+}
+
+// notify wakes everything waiting for a change; b.mu must be held
+func (b *Broker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// group holds the membership and committed offsets of a consumer group
+type group struct {
+	generation int32
+	members    map[string][]string // member ID to subscribed topics
+	committed  map[string]map[int32]int64
+	rebalance  chan struct{} // closed when the generation changes
+	sessions   map[int32]int // running sessions by generation
+}
+
+// group returns the consumer group, creating it if needed; b.mu must be held
+func (b *Broker) group(groupID string) *group {
+	g, ok := b.groups[groupID]
+	if !ok {
+		g = &group{
+			members:   make(map[string][]string),
+			committed: make(map[string]map[int32]int64),
+			rebalance: make(chan struct{}),
+			sessions:  make(map[int32]int),
+		}
+		b.groups[groupID] = g
+	}
+	return g
+}
+
+// bump starts a new generation, ending the sessions of the current one; b.mu must be held
+func (g *group) bump() {
+	g.generation++
+	close(g.rebalance)
+	g.rebalance = make(chan struct{})
+}
+
+// stale reports whether sessions of an earlier generation are still running
+func (g *group) stale() bool {
+	for generation, n := range g.sessions {
+		if generation != g.generation && n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// assign spreads the partitions of every subscribed topic round-robin over
+// the members subscribed to it and returns memberID's share; b.mu must be held
+func (b *Broker) assign(g *group, memberID string) map[string][]int32 {
+	subscribers := make(map[string][]string)
+	for member, topics := range g.members {
+		for _, topic := range topics {
+			subscribers[topic] = append(subscribers[topic], member)
+		}
+	}
+
+	claims := make(map[string][]int32)
+	for topic, members := range subscribers {
+		sort.Strings(members)
+		for partition := range b.topic(topic) {
+			if members[partition%len(members)] == memberID {
+				claims[topic] = append(claims[topic], int32(partition))
+			}
+		}
+	}
+	return claims
+}
+
+func (b *Broker) commit(groupID, topic string, partition int32, offset int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.group(groupID)
+	if g.committed[topic] == nil {
+		g.committed[topic] = make(map[int32]int64)
+	}
+	g.committed[topic][partition] = offset
+	b.notify()
+}
+
+func copyMessage(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	out := *msg
+	out.Headers = append([]*sarama.RecordHeader(nil), msg.Headers...)
+	return &out
+}
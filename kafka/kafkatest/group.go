@@ -0,0 +1,343 @@
+package kafkatest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+)
+
+// ConsumerGroup is an in-memory sarama.ConsumerGroup member. Members of the
+// same group share partitions, and a member joining or leaving ends the
+// current session of every member, like a rebalance. Partitions without a
+// committed offset are consumed from the oldest message.
+type ConsumerGroup struct {
+	broker   *Broker
+	groupID  string
+	memberID string
+	errs     chan error
+
+	mu        sync.Mutex
+	pausedAll bool
+	paused    map[string]map[int32]bool
+	closed    bool
+}
+
+var _ sarama.ConsumerGroup = (*ConsumerGroup)(nil)
+
+// ConsumerGroup returns a new member of consumer group groupID
+func (b *Broker) ConsumerGroup(groupID string) *ConsumerGroup {
+	return &ConsumerGroup{
+		broker:   b,
+		groupID:  groupID,
+		memberID: groupID + "-" + uuid.NewString(),
+		errs:     make(chan error, 256),
+		paused:   make(map[string]map[int32]bool),
+	}
+}
+
+// Consume joins the group and runs one session, returning when ctx ends or
+// the group rebalances
+func (cg *ConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	if len(topics) == 0 {
+		return errors.New("no topics provided")
+	}
+	cg.mu.Lock()
+	closed := cg.closed
+	cg.mu.Unlock()
+	if closed {
+		return sarama.ErrClosedConsumerGroup
+	}
+
+	sess, err := cg.join(ctx, topics)
+	if err != nil {
+		return err
+	}
+	defer cg.leaveSession(sess)
+
+	if err := handler.Setup(sess); err != nil {
+		sess.cancel()
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for topic, partitions := range sess.claims {
+		for _, partition := range partitions {
+			c := cg.newClaim(sess, topic, partition)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				c.feed(sess.ctx)
+			}()
+			go func() {
+				defer wg.Done()
+				// Like sarama, the first claim to end ends the session
+				defer sess.cancel()
+				if err := handler.ConsumeClaim(sess, c); err != nil {
+					cg.handleError(err)
+				}
+			}()
+		}
+	}
+	if len(sess.claims) == 0 {
+		<-sess.ctx.Done()
+	}
+	wg.Wait()
+
+	return handler.Cleanup(sess)
+}
+
+// join registers the member and waits for the sessions of earlier generations to end
+func (cg *ConsumerGroup) join(ctx context.Context, topics []string) (*session, error) {
+	b := cg.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g := b.group(cg.groupID)
+	if subscribed, ok := g.members[cg.memberID]; !ok || !slices.Equal(subscribed, topics) {
+		g.members[cg.memberID] = slices.Clone(topics)
+		g.bump()
+		b.notify()
+	}
+
+	for g.stale() {
+		changed := b.changed
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			b.mu.Lock()
+			return nil, ctx.Err()
+		}
+		b.mu.Lock()
+	}
+
+	sess := &session{
+		group:      cg,
+		generation: g.generation,
+		claims:     b.assign(g, cg.memberID),
+	}
+	sess.ctx, sess.cancel = context.WithCancel(ctx)
+	g.sessions[sess.generation]++
+
+	rebalance := g.rebalance
+	go func() {
+		select {
+		case <-rebalance:
+			sess.cancel()
+		case <-sess.ctx.Done():
+		}
+	}()
+	return sess, nil
+}
+
+func (cg *ConsumerGroup) leaveSession(sess *session) {
+	sess.cancel()
+	b := cg.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.group(cg.groupID).sessions[sess.generation]--
+	b.notify()
+}
+
+func (cg *ConsumerGroup) handleError(err error) {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	if cg.closed {
+		return
+	}
+	select {
+	case cg.errs <- err:
+	default:
+	}
+}
+
+// Errors returns errors from ConsumeClaim
+func (cg *ConsumerGroup) Errors() <-chan error {
+	return cg.errs
+}
+
+// Close leaves the group, rebalancing the remaining members
+func (cg *ConsumerGroup) Close() error {
+	cg.mu.Lock()
+	if cg.closed {
+		cg.mu.Unlock()
+		return nil
+	}
+	cg.closed = true
+	close(cg.errs)
+	cg.mu.Unlock()
+
+	b := cg.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.group(cg.groupID)
+	if _, ok := g.members[cg.memberID]; ok {
+		delete(g.members, cg.memberID)
+		g.bump()
+		b.notify()
+	}
+	return nil
+}
+
+// Pause stops delivering messages from the given partitions
+func (cg *ConsumerGroup) Pause(partitions map[string][]int32) {
+	cg.setPaused(partitions, true)
+}
+
+// Resume resumes delivering messages from the given partitions
+func (cg *ConsumerGroup) Resume(partitions map[string][]int32) {
+	cg.setPaused(partitions, false)
+}
+
+// PauseAll stops delivering messages from every partition
+func (cg *ConsumerGroup) PauseAll() {
+	cg.mu.Lock()
+	cg.pausedAll = true
+	cg.mu.Unlock()
+	cg.wake()
+}
+
+// ResumeAll resumes delivering messages from every partition
+func (cg *ConsumerGroup) ResumeAll() {
+	cg.mu.Lock()
+	cg.pausedAll = false
+	cg.paused = make(map[string]map[int32]bool)
+	cg.mu.Unlock()
+	cg.wake()
+}
+
+func (cg *ConsumerGroup) setPaused(partitions map[string][]int32, paused bool) {
+	cg.mu.Lock()
+	for topic, ps := range partitions {
+		if cg.paused[topic] == nil {
+			cg.paused[topic] = make(map[int32]bool)
+		}
+		for _, p := range ps {
+			cg.paused[topic][p] = paused
+		}
+	}
+	cg.mu.Unlock()
+	cg.wake()
+}
+
+func (cg *ConsumerGroup) isPaused(topic string, partition int32) bool {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	return cg.pausedAll || cg.paused[topic][partition]
+}
+
+// wake makes claim feeders re-check their paused state
+func (cg *ConsumerGroup) wake() {
+	cg.broker.mu.Lock()
+	defer cg.broker.mu.Unlock()
+	cg.broker.notify()
+}
+
+// session is a sarama.ConsumerGroupSession for one generation
+type session struct {
+	group      *ConsumerGroup
+	generation int32
+	claims     map[string][]int32
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func (s *session) Claims() map[string][]int32 { return s.claims }
+func (s *session) MemberID() string           { return s.group.memberID }
+func (s *session) GenerationID() int32        { return s.generation }
+func (s *session) Context() context.Context   { return s.ctx }
+
+// Commit is a no-op; marked offsets are committed immediately
+func (s *session) Commit() {}
+
+// MarkOffset commits offset as the next offset to consume if it is ahead of the committed one
+func (s *session) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	if offset > s.group.broker.CommittedOffset(s.group.groupID, topic, partition) {
+		s.group.broker.commit(s.group.groupID, topic, partition, offset)
+	}
+}
+
+// ResetOffset commits offset as the next offset to consume if it is behind the committed one or none is committed
+func (s *session) ResetOffset(topic string, partition int32, offset int64, _ string) {
+	if committed := s.group.broker.CommittedOffset(s.group.groupID, topic, partition); committed < 0 || offset < committed {
+		s.group.broker.commit(s.group.groupID, topic, partition, offset)
+	}
+}
+
+// MarkMessage marks msg as consumed
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// claim is a sarama.ConsumerGroupClaim fed from a partition log
+type claim struct {
+	group     *ConsumerGroup
+	topic     string
+	partition int32
+	initial   int64
+	messages  chan *sarama.ConsumerMessage
+}
+
+func (cg *ConsumerGroup) newClaim(sess *session, topic string, partition int32) *claim {
+	initial := cg.broker.CommittedOffset(cg.groupID, topic, partition)
+	if initial < 0 {
+		initial = 0
+	}
+	return &claim{
+		group:     sess.group,
+		topic:     topic,
+		partition: partition,
+		initial:   initial,
+		messages:  make(chan *sarama.ConsumerMessage),
+	}
+}
+
+func (c *claim) Topic() string                            { return c.topic }
+func (c *claim) Partition() int32                         { return c.partition }
+func (c *claim) InitialOffset() int64                     { return c.initial }
+func (c *claim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// HighWaterMarkOffset returns the offset of the next message to be produced
+func (c *claim) HighWaterMarkOffset() int64 {
+	b := c.group.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(len(b.topic(c.topic)[c.partition]))
+}
+
+// feed delivers messages from the initial offset until ctx ends, then closes Messages
+func (c *claim) feed(ctx context.Context) {
+	defer close(c.messages)
+	b := c.group.broker
+	next := c.initial
+	for {
+		b.mu.Lock()
+		log := b.topic(c.topic)[c.partition]
+		changed := b.changed
+		var msg *sarama.ConsumerMessage
+		if next < int64(len(log)) {
+			msg = log[next]
+		}
+		b.mu.Unlock()
+
+		if msg == nil || c.group.isPaused(c.topic, c.partition) {
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case c.messages <- copyMessage(msg):
+			next++
+		case <-ctx.Done():
+			return
+		}
+	}
+}
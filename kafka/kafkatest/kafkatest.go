@@ -0,0 +1,128 @@
+package kafkatest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"github.com/banking/shared/kafka"
+	"go.uber.org/zap/zaptest"
+)
+
+// NewProducer returns a kafka.Producer with the default config publishing to
+// b. It is closed when the test ends.
+func NewProducer(t testing.TB, b *Broker) *kafka.Producer {
+	t.Helper()
+	p, err := kafka.NewProducerFromClient(kafka.DefaultProducerConfig([]string{Addr}, "kafkatest"), b.SyncProducer(), zaptest.NewLogger(t))
+	if err != nil {
+		t.Fatalf("failed to create producer: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+// StartConsumer creates a kafka.Consumer for cfg consuming from b and starts
+// it. Only GroupID, Topics and the handling options of cfg are used. The
+// consumer is stopped when the test ends.
+func StartConsumer(t testing.TB, b *Broker, cfg kafka.ConsumerConfig, handler kafka.MessageHandler) *kafka.Consumer {
+	t.Helper()
+	c, err := kafka.NewConsumerFromClient(cfg, b.ConsumerGroup(cfg.GroupID), handler, zaptest.NewLogger(t))
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	// The context bounds the consumer's lifetime, so it is only cancelled
+	// early if the consumer fails to join in time
+	ctx, cancel := context.WithCancel(context.Background())
+	joinTimeout := time.AfterFunc(5*time.Second, cancel)
+	if err := c.Start(ctx); err != nil {
+		cancel()
+		t.Fatalf("failed to start consumer: %v", err)
+	}
+	joinTimeout.Stop()
+	t.Cleanup(func() {
+		_ = c.Stop()
+		cancel()
+	})
+	return c
+}
+
+// ExpectMessage waits up to timeout for a message on topic that satisfies
+// match and returns the first one. It fails the test if none arrives.
+// Messages produced before the call are considered too.
+func (b *Broker) ExpectMessage(t testing.TB, topic string, match func(*sarama.ConsumerMessage) bool, timeout time.Duration) *sarama.ConsumerMessage {
+	t.Helper()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		b.mu.Lock()
+		changed := b.changed
+		b.mu.Unlock()
+
+		for _, msg := range b.Messages(topic) {
+			if match(msg) {
+				return msg
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			t.Fatalf("no matching message on %s within %s", topic, timeout)
+			return nil
+		}
+	}
+}
+
+// ExpectEvent waits up to timeout for an event of eventType on topic and
+// returns its message. It fails the test if none arrives.
+func (b *Broker) ExpectEvent(t testing.TB, topic string, eventType events.EventType, timeout time.Duration) *sarama.ConsumerMessage {
+	t.Helper()
+	return b.ExpectMessage(t, topic, func(msg *sarama.ConsumerMessage) bool {
+		var event events.BaseEvent
+		return json.Unmarshal(msg.Value, &event) == nil && event.EventType == eventType
+	}, timeout)
+}
+
+// ExpectCommitted waits up to timeout for groupID to commit every message
+// currently on topic. It fails the test otherwise.
+func (b *Broker) ExpectCommitted(t testing.TB, groupID, topic string, timeout time.Duration) {
+	t.Helper()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		b.mu.Lock()
+		changed := b.changed
+		pending := b.uncommitted(groupID, topic)
+		b.mu.Unlock()
+		if pending == 0 {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			t.Fatalf("%d messages on %s not committed by %s within %s", pending, topic, groupID, timeout)
+			return
+		}
+	}
+}
+
+// uncommitted returns the number of messages on topic after groupID's committed offsets; b.mu must be held
+func (b *Broker) uncommitted(groupID, topic string) int64 {
+	var pending int64
+	g := b.group(groupID)
+	for partition, log := range b.topic(topic) {
+		committed, ok := g.committed[topic][int32(partition)]
+		if !ok {
+			committed = 0
+		}
+		pending += max(int64(len(log))-committed, 0)
+	}
+	return pending
+}
@@ -0,0 +1,171 @@
+package kafkatest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"github.com/banking/shared/kafka"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	initiatedTopic = "banking.transactions.initiated"
+	completedTopic = "banking.transactions.completed"
+)
+
+func initiated(userID uuid.UUID) *events.TransactionInitiatedEvent {
+	return &events.TransactionInitiatedEvent{
+		BaseEvent:     events.NewBaseEvent(events.EventTypeTransactionInitiated, "test"),
+		TransactionID: uuid.New(),
+		UserID:        userID,
+	}
+}
+
+// eventIDs collects the event IDs seen by a handler
+type eventIDs struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (e *eventIDs) handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var event events.BaseEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ids = append(e.ids, event.EventID.String())
+	return nil
+}
+
+func (e *eventIDs) len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.ids)
+}
+
+func TestBroker_PartitionsByKey(t *testing.T) {
+	b := NewBroker()
+	require.NoError(t, b.CreateTopic(initiatedTopic, 4))
+
+	key := sarama.StringEncoder("user-1")
+	first, _, err := b.Produce(&sarama.ProducerMessage{Topic: initiatedTopic, Key: key, Value: sarama.StringEncoder("1")})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		partition, _, err := b.Produce(&sarama.ProducerMessage{Topic: initiatedTopic, Key: key, Value: sarama.StringEncoder("n")})
+		require.NoError(t, err)
+		assert.Equal(t, first, partition, "the same key always maps to the same partition")
+	}
+
+	var partitions []int32
+	for i := 0; i < 4; i++ {
+		partition, offset, err := b.Produce(&sarama.ProducerMessage{Topic: initiatedTopic, Value: sarama.StringEncoder("n")})
+		require.NoError(t, err)
+		partitions = append(partitions, partition)
+		assert.GreaterOrEqual(t, offset, int64(0))
+	}
+	assert.ElementsMatch(t, []int32{0, 1, 2, 3}, partitions, "messages without a key are spread round-robin")
+	assert.Len(t, b.Messages(initiatedTopic), 10)
+}
+
+func TestBroker_CreateTopicRequiresPartitions(t *testing.T) {
+	b := NewBroker()
+	assert.Error(t, b.CreateTopic(initiatedTopic, 0))
+	assert.Error(t, b.CreateTopic(initiatedTopic, -1))
+
+	// The topic was not created, so it is still created on first use
+	_, _, err := b.Produce(&sarama.ProducerMessage{Topic: initiatedTopic, Value: sarama.StringEncoder("1")})
+	require.NoError(t, err)
+}
+
+func TestBroker_ProducerAndConsumerRoundTrip(t *testing.T) {
+	b := NewBroker()
+	producer := NewProducer(t, b)
+	ctx := context.Background()
+
+	// The service under test: completes every initiated transaction
+	StartConsumer(t, b, kafka.ConsumerConfig{GroupID: "payments", Topics: []string{initiatedTopic}},
+		func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			var in events.TransactionInitiatedEvent
+			if err := json.Unmarshal(msg.Value, &in); err != nil {
+				return err
+			}
+			return producer.Publish(ctx, completedTopic, &events.TransactionCompletedEvent{
+				BaseEvent:     events.NewBaseEvent(events.EventTypeTransactionCompleted, "payments").WithCorrelation(in.EventID.String()),
+				TransactionID: in.TransactionID,
+				UserID:        in.UserID,
+			})
+		})
+
+	event := initiated(uuid.New())
+	require.NoError(t, producer.Publish(ctx, initiatedTopic, event))
+
+	msg := b.ExpectEvent(t, completedTopic, events.EventTypeTransactionCompleted, time.Second)
+	var completed events.TransactionCompletedEvent
+	require.NoError(t, json.Unmarshal(msg.Value, &completed))
+	assert.Equal(t, event.EventID.String(), completed.CorrelationID)
+	assert.Equal(t, event.TransactionID.String(), string(msg.Key))
+
+	b.ExpectCommitted(t, "payments", initiatedTopic, time.Second)
+}
+
+func TestBroker_ConsumerGroupSharesPartitionsAndResumesFromCommits(t *testing.T) {
+	b := NewBroker()
+	producer := NewProducer(t, b)
+	ctx := context.Background()
+	cfg := kafka.ConsumerConfig{GroupID: "fraud", Topics: []string{initiatedTopic}}
+
+	var first, second eventIDs
+	c1 := StartConsumer(t, b, cfg, first.handle)
+	c2 := StartConsumer(t, b, cfg, second.handle)
+
+	require.Eventually(t, func() bool {
+		h1, h2 := c1.Health(), c2.Health()
+		return h1.Ready() && h2.Ready() && h1.Generation == h2.Generation
+	}, time.Second, 5*time.Millisecond)
+	assigned := len(c1.Health().Partitions[initiatedTopic]) + len(c2.Health().Partitions[initiatedTopic])
+	assert.Equal(t, DefaultPartitions, assigned, "members split the partitions")
+
+	for i := 0; i < 12; i++ {
+		require.NoError(t, producer.Publish(ctx, initiatedTopic, initiated(uuid.New())))
+	}
+	b.ExpectCommitted(t, "fraud", initiatedTopic, time.Second)
+	assert.Equal(t, 12, first.len()+second.len(), "each message is handled once")
+	assert.NotZero(t, first.len())
+	assert.NotZero(t, second.len())
+
+	// The remaining member takes over every partition from the committed offsets
+	require.NoError(t, c2.Stop())
+	handledBefore := first.len()
+	for i := 0; i < 6; i++ {
+		require.NoError(t, producer.Publish(ctx, initiatedTopic, initiated(uuid.New())))
+	}
+	b.ExpectCommitted(t, "fraud", initiatedTopic, time.Second)
+	assert.Equal(t, handledBefore+6, first.len())
+}
+
+func TestBroker_ProduceError(t *testing.T) {
+	b := NewBroker()
+	p := b.SyncProducer()
+	errDown := errors.New("broker down")
+
+	b.SetProduceError(initiatedTopic, errDown)
+	_, _, err := p.SendMessage(&sarama.ProducerMessage{Topic: initiatedTopic, Value: sarama.StringEncoder("1")})
+	assert.ErrorIs(t, err, errDown)
+
+	b.SetProduceError(initiatedTopic, nil)
+	_, _, err = p.SendMessage(&sarama.ProducerMessage{Topic: initiatedTopic, Value: sarama.StringEncoder("1")})
+	assert.NoError(t, err)
+
+	require.NoError(t, p.Close())
+	_, _, err = p.SendMessage(&sarama.ProducerMessage{Topic: initiatedTopic, Value: sarama.StringEncoder("1")})
+	assert.Error(t, err)
+}
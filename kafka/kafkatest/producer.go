@@ -0,0 +1,73 @@
+package kafkatest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrTransactionsUnsupported is returned by the transaction methods of SyncProducer
+var ErrTransactionsUnsupported = errors.New("kafkatest: transactions are not supported")
+
+// SyncProducer is an in-memory sarama.SyncProducer writing to a Broker
+type SyncProducer struct {
+	broker *Broker
+
+	mu     sync.Mutex
+	closed bool
+}
+
+var _ sarama.SyncProducer = (*SyncProducer)(nil)
+
+// SyncProducer returns a producer writing to b
+func (b *Broker) SyncProducer() *SyncProducer {
+	return &SyncProducer{broker: b}
+}
+
+// SendMessage writes msg to the broker
+func (p *SyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return 0, 0, sarama.ErrClosedClient
+	}
+	return p.broker.Produce(msg)
+}
+
+// SendMessages writes each message, returning sarama.ProducerErrors for those that failed
+func (p *SyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errs sarama.ProducerErrors
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			errs = append(errs, &sarama.ProducerError{Msg: msg, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Close makes further sends fail
+func (p *SyncProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *SyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag { return sarama.ProducerTxnFlagReady }
+func (p *SyncProducer) IsTransactional() bool                   { return false }
+func (p *SyncProducer) BeginTxn() error                         { return ErrTransactionsUnsupported }
+func (p *SyncProducer) CommitTxn() error                        { return ErrTransactionsUnsupported }
+func (p *SyncProducer) AbortTxn() error                         { return ErrTransactionsUnsupported }
+
+func (p *SyncProducer) AddOffsetsToTxn(map[string][]*sarama.PartitionOffsetMetadata, string) error {
+	return ErrTransactionsUnsupported
+}
+
+func (p *SyncProducer) AddMessageToTxn(*sarama.ConsumerMessage, string, *string) error {
+	return ErrTransactionsUnsupported
+}
@@ -0,0 +1,301 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+const meterName = "banking-shared/kafka"
+
+// Metric names follow the OpenTelemetry messaging semantic conventions where
+// one exists; lag and breaker state use the messaging.kafka namespace.
+const (
+	metricPublishDuration = "messaging.publish.duration"
+	metricPublishMessages = "messaging.publish.messages"
+	metricDeliverDuration = "messaging.deliver.duration"
+	metricDeliverMessages = "messaging.deliver.messages"
+	metricConsumerLag     = "messaging.kafka.consumer.lag"
+	metricBreakerState    = "messaging.kafka.producer.breaker.state"
+)
+
+// durationBuckets are histogram boundaries in seconds suited to broker round trips and handlers
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func meterProvider(mp metric.MeterProvider) metric.MeterProvider {
+	if mp == nil {
+		return otel.GetMeterProvider()
+	}
+	return mp
+}
+
+// errorType returns the error.type attribute value for a failed operation
+func errorType(err error) attribute.KeyValue {
+	switch {
+	case errors.Is(err, gobreaker.ErrOpenState), errors.Is(err, gobreaker.ErrTooManyRequests):
+		return semconv.ErrorTypeKey.String("circuit_open")
+	case errors.Is(err, context.DeadlineExceeded):
+		return semconv.ErrorTypeKey.String("timeout")
+	case errors.Is(err, context.Canceled):
+		return semconv.ErrorTypeKey.String("canceled")
+	case IsNonRetryable(err):
+		return semconv.ErrorTypeKey.String("non_retryable")
+	default:
+		return semconv.ErrorTypeOther
+	}
+}
+
+// producerMetrics records publish latency and outcomes. A nil *producerMetrics records nothing.
+type producerMetrics struct {
+	duration metric.Float64Histogram
+	messages metric.Int64Counter
+	breaker  metric.Registration
+}
+
+// newProducerMetrics creates the publish instruments and a gauge observing the breaker state
+func newProducerMetrics(mp metric.MeterProvider, name string, state func() gobreaker.State) (*producerMetrics, error) {
+	meter := meterProvider(mp).Meter(meterName)
+
+	duration, err := meter.Float64Histogram(metricPublishDuration,
+		metric.WithDescription("Duration of publish operations"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s histogram: %w", metricPublishDuration, err)
+	}
+
+	messages, err := meter.Int64Counter(metricPublishMessages,
+		metric.WithDescription("Number of messages published"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s counter: %w", metricPublishMessages, err)
+	}
+
+	gauge, err := meter.Int64ObservableGauge(metricBreakerState,
+		metric.WithDescription("Circuit breaker state: 0 closed, 1 half-open, 2 open"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s gauge: %w", metricBreakerState, err)
+	}
+	breakerAttrs := metric.WithAttributes(semconv.MessagingSystemKafka, attribute.String("name", name))
+	breaker, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(gauge, int64(state()), breakerAttrs)
+		return nil
+	}, gauge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register %s callback: %w", metricBreakerState, err)
+	}
+
+	return &producerMetrics{duration: duration, messages: messages, breaker: breaker}, nil
+}
+
+// unregister stops observing the breaker state so a closed producer is not
+// reported and can be garbage collected
+func (m *producerMetrics) unregister() error {
+	if m == nil {
+		return nil
+	}
+	return m.breaker.Unregister()
+}
+
+// recordPublish records a publish request that started at start, in which
+// sent messages succeeded and failed messages failed with err
+func (m *producerMetrics) recordPublish(ctx context.Context, topic string, start time.Time, sent, failed int, err error) {
+	if m == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKafka,
+		semconv.MessagingOperationPublish,
+		semconv.MessagingDestinationName(topic),
+	}
+	if sent == 0 && err != nil {
+		attrs = append(attrs, errorType(err))
+	}
+	m.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+
+	if sent > 0 {
+		m.messages.Add(ctx, int64(sent), metric.WithAttributes(attrs...))
+	}
+	if failed > 0 && err != nil {
+		m.messages.Add(ctx, int64(failed), metric.WithAttributes(append(attrs[:3:3], errorType(err))...))
+	}
+}
+
+// consumerMetrics records handler latency, outcomes and per-partition lag.
+// A nil *consumerMetrics records nothing.
+type consumerMetrics struct {
+	groupID  string
+	duration metric.Float64Histogram
+	messages metric.Int64Counter
+	lag      metric.Int64ObservableGauge
+	lagReg   metric.Registration
+
+	mu     sync.Mutex
+	claims map[*claimLag]struct{}
+}
+
+// claimLag tracks the next offset to commit for one partition claim
+type claimLag struct {
+	claim sarama.ConsumerGroupClaim
+	attrs metric.MeasurementOption
+	next  atomic.Int64
+}
+
+func newConsumerMetrics(mp metric.MeterProvider, groupID string) (*consumerMetrics, error) {
+	meter := meterProvider(mp).Meter(meterName)
+	m := &consumerMetrics{groupID: groupID, claims: make(map[*claimLag]struct{})}
+
+	var err error
+	m.duration, err = meter.Float64Histogram(metricDeliverDuration,
+		metric.WithDescription("Duration of message handler invocations"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s histogram: %w", metricDeliverDuration, err)
+	}
+
+	m.messages, err = meter.Int64Counter(metricDeliverMessages,
+		metric.WithDescription("Number of messages delivered to the handler"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s counter: %w", metricDeliverMessages, err)
+	}
+
+	m.lag, err = meter.Int64ObservableGauge(metricConsumerLag,
+		metric.WithDescription("Messages between the partition high-water mark and the last committed offset"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s gauge: %w", metricConsumerLag, err)
+	}
+	if m.lagReg, err = meter.RegisterCallback(m.observeLag, m.lag); err != nil {
+		return nil, fmt.Errorf("failed to register %s callback: %w", metricConsumerLag, err)
+	}
+
+	return m, nil
+}
+
+// unregister stops observing lag so a stopped consumer is not reported and
+// can be garbage collected
+func (m *consumerMetrics) unregister() error {
+	if m == nil {
+		return nil
+	}
+	return m.lagReg.Unregister()
+}
+
+// recordDelivery records one handler invocation that started at start
+func (m *consumerMetrics) recordDelivery(ctx context.Context, msg *sarama.ConsumerMessage, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKafka,
+		semconv.MessagingOperationDeliver,
+		semconv.MessagingDestinationName(msg.Topic),
+		semconv.MessagingKafkaDestinationPartition(int(msg.Partition)),
+		semconv.MessagingKafkaConsumerGroup(m.groupID),
+	}
+	if err != nil {
+		attrs = append(attrs, errorType(err))
+	}
+	opt := metric.WithAttributes(attrs...)
+	m.duration.Record(ctx, time.Since(start).Seconds(), opt)
+	m.messages.Add(ctx, 1, opt)
+}
+
+// track starts observing lag for a claim until untrack is called
+func (m *consumerMetrics) track(claim sarama.ConsumerGroupClaim) *claimLag {
+	if m == nil {
+		return nil
+	}
+	l := &claimLag{
+		claim: claim,
+		attrs: metric.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(claim.Topic()),
+			semconv.MessagingKafkaDestinationPartition(int(claim.Partition())),
+			semconv.MessagingKafkaConsumerGroup(m.groupID),
+		),
+	}
+	l.next.Store(claim.InitialOffset())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims[l] = struct{}{}
+	return l
+}
+
+func (m *consumerMetrics) untrack(l *claimLag) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.claims, l)
+}
+
+func (m *consumerMetrics) observeLag(_ context.Context, o metric.Observer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for l := range m.claims {
+		next := l.next.Load()
+		if next < 0 {
+			// Initial offset is still a sentinel (newest/oldest) and nothing is committed yet
+			continue
+		}
+		o.ObserveInt64(m.lag, max(l.claim.HighWaterMarkOffset()-next, 0), l.attrs)
+	}
+	return nil
+}
+
+// advance records that every offset before next is committed
+func (l *claimLag) advance(next int64) {
+	if l == nil {
+		return
+	}
+	for {
+		cur := l.next.Load()
+		if next <= cur || l.next.CompareAndSwap(cur, next) {
+			return
+		}
+	}
+}
+
+// wrap returns a session that reports marked offsets to the lag tracker
+func (l *claimLag) wrap(session sarama.ConsumerGroupSession) sarama.ConsumerGroupSession {
+	if l == nil {
+		return session
+	}
+	return lagSession{ConsumerGroupSession: session, lag: l}
+}
+
+type lagSession struct {
+	sarama.ConsumerGroupSession
+	lag *claimLag
+}
+
+func (s lagSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.ConsumerGroupSession.MarkMessage(msg, metadata)
+	s.lag.advance(msg.Offset + 1)
+}
+
+func (s lagSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.ConsumerGroupSession.MarkOffset(topic, partition, offset, metadata)
+	s.lag.advance(offset)
+}
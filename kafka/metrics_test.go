@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func newTestMeterProvider() (*sdkmetric.MeterProvider, *sdkmetric.ManualReader) {
+	reader := sdkmetric.NewManualReader()
+	return sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), reader
+}
+
+// collectMetrics returns the collected metrics by name
+func collectMetrics(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+
+	out := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			out[m.Name] = m.Data
+		}
+	}
+	return out
+}
+
+// sumByErrorType sums counter data points by their error.type attribute ("" for success)
+func sumByErrorType(t *testing.T, data metricdata.Aggregation) map[string]int64 {
+	t.Helper()
+	sum, ok := data.(metricdata.Sum[int64])
+	require.True(t, ok)
+
+	out := make(map[string]int64)
+	for _, dp := range sum.DataPoints {
+		v, _ := dp.Attributes.Value(semconv.ErrorTypeKey)
+		out[v.AsString()] += dp.Value
+	}
+	return out
+}
+
+func TestConsumer_RecordsDeliveryMetrics(t *testing.T) {
+	mp, reader := newTestMeterProvider()
+	metrics, err := newConsumerMetrics(mp, "test-group")
+	require.NoError(t, err)
+
+	calls := 0
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		calls++
+		if msg.Offset == 2 {
+			return NonRetryable(errors.New("bad payload"))
+		}
+		return nil
+	}, nil)
+	c.metrics = metrics
+
+	session := newFakeSession(context.Background())
+	require.Error(t, c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(1), testMessage(2))))
+
+	collected := collectMetrics(t, reader)
+	assert.Equal(t, map[string]int64{"": 1, "non_retryable": 1}, sumByErrorType(t, collected[metricDeliverMessages]))
+
+	hist, ok := collected[metricDeliverDuration].(metricdata.Histogram[float64])
+	require.True(t, ok)
+	var count uint64
+	for _, dp := range hist.DataPoints {
+		count += dp.Count
+	}
+	assert.Equal(t, uint64(2), count)
+}
+
+func TestConsumer_ObservesLagFromHighWaterMark(t *testing.T) {
+	mp, reader := newTestMeterProvider()
+	metrics, err := newConsumerMetrics(mp, "test-group")
+	require.NoError(t, err)
+
+	claim := newFakeClaim("banking.transactions.initiated", 2, testMessage(0), testMessage(1), testMessage(2))
+	lag := metrics.track(claim)
+	session := lag.wrap(newFakeSession(context.Background()))
+
+	observe := func() int64 {
+		gauge, ok := collectMetrics(t, reader)[metricConsumerLag].(metricdata.Gauge[int64])
+		require.True(t, ok)
+		require.Len(t, gauge.DataPoints, 1)
+		partition, _ := gauge.DataPoints[0].Attributes.Value(attribute.Key("messaging.kafka.destination.partition"))
+		assert.Equal(t, int64(2), partition.AsInt64())
+		return gauge.DataPoints[0].Value
+	}
+
+	// High-water mark is 3 and nothing is committed yet
+	assert.Equal(t, int64(3), observe())
+
+	session.MarkMessage(testMessage(0), "")
+	session.MarkMessage(testMessage(1), "")
+	assert.Equal(t, int64(1), observe())
+
+	metrics.untrack(lag)
+	_, ok := collectMetrics(t, reader)[metricConsumerLag]
+	assert.False(t, ok, "untracked claims are not observed")
+}
+
+func TestProducer_RecordsPublishMetrics(t *testing.T) {
+	mp, reader := newTestMeterProvider()
+	p, mockProducer := newTestProducer(t)
+	p.cb = gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        "kafka-producer-test",
+		ReadyToTrip: func(counts gobreaker.Counts) bool { return counts.ConsecutiveFailures >= 1 },
+	})
+	metrics, err := newProducerMetrics(mp, "kafka-producer-test", p.cb.State)
+	require.NoError(t, err)
+	p.metrics = metrics
+	ctx := context.Background()
+
+	mockProducer.ExpectSendMessageAndSucceed()
+	require.NoError(t, p.Publish(ctx, "banking.audit", MockEvent{ID: "1"}))
+	mockProducer.ExpectSendMessageAndFail(errors.New("broker down"))
+	require.Error(t, p.Publish(ctx, "banking.audit", MockEvent{ID: "2"}))
+	require.Error(t, p.Publish(ctx, "banking.audit", MockEvent{ID: "3"}))
+
+	collected := collectMetrics(t, reader)
+	assert.Equal(t, map[string]int64{"": 1, "_OTHER": 1, "circuit_open": 1}, sumByErrorType(t, collected[metricPublishMessages]))
+
+	state, ok := collected[metricBreakerState].(metricdata.Gauge[int64])
+	require.True(t, ok)
+	require.Len(t, state.DataPoints, 1)
+	assert.Equal(t, int64(gobreaker.StateOpen), state.DataPoints[0].Value)
+}
+
+func TestMetrics_UnregisteredAfterClose(t *testing.T) {
+	mp, reader := newTestMeterProvider()
+
+	p, _ := newTestProducer(t)
+	producerMetrics, err := newProducerMetrics(mp, "kafka-producer-test", p.cb.State)
+	require.NoError(t, err)
+	p.metrics = producerMetrics
+
+	c := newTestConsumer(t, nil, nil)
+	c.client = newFakeGroup(nil)
+	consumerMetrics, err := newConsumerMetrics(mp, "test-group")
+	require.NoError(t, err)
+	c.metrics = consumerMetrics
+	consumerMetrics.track(newFakeClaim("banking.transactions.initiated", 2, testMessage(0)))
+
+	collected := collectMetrics(t, reader)
+	require.Contains(t, collected, metricBreakerState)
+	require.Contains(t, collected, metricConsumerLag)
+
+	require.NoError(t, p.Close())
+	require.NoError(t, c.Stop())
+
+	collected = collectMetrics(t, reader)
+	assert.NotContains(t, collected, metricBreakerState, "a closed producer is not observed")
+	assert.NotContains(t, collected, metricConsumerLag, "a stopped consumer is not observed")
+}
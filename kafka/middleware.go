@@ -0,0 +1,157 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Middleware wraps a MessageHandler with cross-cutting behaviour
+type Middleware func(MessageHandler) MessageHandler
+
+// Chain wraps handler with middlewares; the first middleware is the outermost
+func Chain(handler MessageHandler, middlewares ...Middleware) MessageHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// PanicError is the error returned for a handler that panicked
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// callHandler runs handler, recovering a panic into a non-retryable
+// *PanicError so the message is dead-lettered instead of crashing the consumer
+func callHandler(ctx context.Context, handler MessageHandler, msg *sarama.ConsumerMessage, logger *zap.Logger) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr := &PanicError{Value: r, Stack: debug.Stack()}
+			logger.Error("Handler panicked",
+				zap.String("topic", msg.Topic),
+				zap.Int32("partition", msg.Partition),
+				zap.Int64("offset", msg.Offset),
+				zap.Any("panic", r),
+				zap.ByteString("stack", perr.Stack),
+			)
+			err = NonRetryable(perr)
+		}
+	}()
+	return handler(ctx, msg)
+}
+
+type timeoutContextKey struct{}
+
+// timeoutScope records the context a Timeout middleware derived its deadline from
+type timeoutScope struct {
+	parent context.Context
+	d      time.Duration
+}
+
+// Timeout returns middleware that gives each handler call a deadline of d.
+// Handlers must honour ctx for the deadline to take effect.
+func Timeout(d time.Duration) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			scope := timeoutScope{parent: ctx, d: d}
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(context.WithValue(ctx, timeoutContextKey{}, scope), msg)
+		}
+	}
+}
+
+// restartTimeout gives ctx a fresh deadline from the enclosing Timeout
+// middleware, keeping its values and the cancellation of the context the
+// Timeout was applied to
+func restartTimeout(ctx context.Context, scope timeoutScope) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), scope.d)
+	stop := context.AfterFunc(scope.parent, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+type loggerContextKey struct{}
+
+// LoggerFromContext returns the logger added by the Logging middleware,
+// which carries the message's event and correlation IDs
+func LoggerFromContext(ctx context.Context) (*zap.Logger, bool) {
+	logger, ok := ctx.Value(loggerContextKey{}).(*zap.Logger)
+	return logger, ok
+}
+
+// Logging returns middleware that logs each handler call with the message
+// coordinates and the BaseEvent's event type, event ID and correlation ID.
+// The enriched logger is available to the handler through LoggerFromContext.
+func Logging(logger *zap.Logger) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			fields := []zap.Field{
+				zap.String("topic", msg.Topic),
+				zap.Int32("partition", msg.Partition),
+				zap.Int64("offset", msg.Offset),
+			}
+			var event events.BaseEvent
+			if json.Unmarshal(msg.Value, &event) == nil {
+				fields = append(fields,
+					zap.String("event_type", string(event.EventType)),
+					zap.String("event_id", event.EventID.String()),
+					zap.String("correlation_id", event.CorrelationID),
+				)
+			}
+			msgLogger := logger.With(fields...)
+
+			start := time.Now()
+			err := next(context.WithValue(ctx, loggerContextKey{}, msgLogger), msg)
+			if err != nil {
+				msgLogger.Warn("Message handler failed", zap.Duration("duration", time.Since(start)), zap.Error(err))
+				return err
+			}
+			msgLogger.Debug("Message handled", zap.Duration("duration", time.Since(start)))
+			return nil
+		}
+	}
+}
+
+// RateLimit returns middleware that admits at most limit messages per second
+// with bursts of up to burst, shared by every partition of the consumer.
+// Handler calls wait for a token or until ctx ends, which happens when the
+// consumer session ends on rebalance or Stop; the message is then left
+// uncommitted for redelivery. The wait does not count against an enclosing
+// Timeout: its deadline starts once the token is granted.
+func RateLimit(limit rate.Limit, burst int) Middleware {
+	limiter := rate.NewLimiter(limit, burst)
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			scope, timed := ctx.Value(timeoutContextKey{}).(timeoutScope)
+			wait := ctx
+			if timed {
+				wait = scope.parent
+			}
+			if err := limiter.Wait(wait); err != nil {
+				return fmt.Errorf("rate limit wait interrupted: %w", err)
+			}
+			if timed {
+				var cancel context.CancelFunc
+				ctx, cancel = restartTimeout(ctx, scope)
+				defer cancel()
+			}
+			return next(ctx, msg)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/time/rate"
+)
+
+func TestChain_AppliesMiddlewaresOutermostFirst(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				calls = append(calls, name+" before")
+				err := next(ctx, msg)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+
+	handler := Chain(func(context.Context, *sarama.ConsumerMessage) error {
+		calls = append(calls, "handler")
+		return nil
+	}, trace("a"), trace("b"))
+
+	require.NoError(t, handler(context.Background(), testMessage(1)))
+	assert.Equal(t, []string{"a before", "b before", "handler", "b after", "a after"}, calls)
+}
+
+func TestCallHandler_TurnsPanicIntoNonRetryableError(t *testing.T) {
+	handler := func(context.Context, *sarama.ConsumerMessage) error {
+		panic("nil account")
+	}
+
+	err := callHandler(context.Background(), handler, testMessage(1), zaptest.NewLogger(t))
+	require.Error(t, err)
+	assert.True(t, IsNonRetryable(err))
+
+	var perr *PanicError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, "nil account", perr.Value)
+	assert.NotEmpty(t, perr.Stack)
+}
+
+func TestConsumer_ConsumeClaim_RecoversPanics(t *testing.T) {
+	producer, mockProducer := newTestProducer(t)
+	calls := 0
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		calls++
+		if msg.Offset == 1 {
+			panic("nil account")
+		}
+		return nil
+	}, DefaultDeadLetterPolicy(producer))
+	session := newFakeSession(context.Background())
+
+	mockProducer.ExpectSendMessageAndSucceed()
+	require.NoError(t, c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(1), testMessage(2))))
+
+	// The panicking message is dead-lettered without retries and consumption continues
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []int64{1, 2}, session.markedOffsets())
+}
+
+func TestTimeout_SetsDeadline(t *testing.T) {
+	handler := Timeout(10 * time.Millisecond)(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(10*time.Millisecond), deadline, 10*time.Millisecond)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	assert.ErrorIs(t, handler(context.Background(), testMessage(1)), context.DeadlineExceeded)
+}
+
+func TestLogging_AddsCorrelationIDs(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	event := events.NewBaseEvent(events.EventTypeTransactionInitiated, "test").WithCorrelation("corr-123")
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+	msg := testMessage(5)
+	msg.Value = payload
+
+	handler := Logging(zap.New(core))(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		logger, ok := LoggerFromContext(ctx)
+		require.True(t, ok)
+		logger.Info("Analyzing transaction")
+		return errors.New("boom")
+	})
+	require.Error(t, handler(context.Background(), msg))
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		fields := entry.ContextMap()
+		assert.Equal(t, "corr-123", fields["correlation_id"])
+		assert.Equal(t, event.EventID.String(), fields["event_id"])
+		assert.Equal(t, string(events.EventTypeTransactionInitiated), fields["event_type"])
+		assert.Equal(t, int64(5), fields["offset"])
+	}
+	assert.Equal(t, "Message handler failed", entries[1].Message)
+}
+
+func TestRateLimit_WaitsForTokens(t *testing.T) {
+	calls := 0
+	handler := RateLimit(rate.Every(time.Hour), 1)(func(context.Context, *sarama.ConsumerMessage) error {
+		calls++
+		return nil
+	})
+
+	require.NoError(t, handler(context.Background(), testMessage(1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Error(t, handler(ctx, testMessage(2)), "the burst is exhausted")
+	assert.Equal(t, 1, calls)
+}
+
+func TestRateLimit_WaitsOutsideTimeout(t *testing.T) {
+	var deadlines []time.Time
+	handler := Chain(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		deadlines = append(deadlines, deadline)
+		return ctx.Err()
+	}, Timeout(20*time.Millisecond), RateLimit(rate.Every(50*time.Millisecond), 1))
+
+	require.NoError(t, handler(context.Background(), testMessage(1)))
+	// The token takes longer than the timeout; the wait must not use it up
+	require.NoError(t, handler(context.Background(), testMessage(2)))
+	require.Len(t, deadlines, 2)
+	assert.GreaterOrEqual(t, deadlines[1].Sub(deadlines[0]), 40*time.Millisecond)
+}
+
+func TestRateLimit_WaitEndsWithSession(t *testing.T) {
+	dlq, mockProducer := newTestProducer(t)
+	c := newTestConsumer(t, Chain(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, RateLimit(rate.Every(time.Hour), 1)), &DeadLetterPolicy{Producer: dlq, MaxAttempts: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	session := newFakeSession(ctx)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(0), testMessage(1)))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-result:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("rate limit wait did not end with the session")
+	}
+	assert.Equal(t, []int64{0}, session.markedOffsets(), "the waiting message is left for redelivery")
+	assert.NoError(t, mockProducer.Close(), "nothing is dead-lettered")
+}
@@ -4,13 +4,17 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
 	"github.com/sony/gobreaker"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
@@ -24,6 +28,68 @@ type ProducerConfig struct {
 	FlushFrequency  time.Duration
 	FlushMessages   int
 	CompressionType sarama.CompressionCodec
+
+	// TransactionalID enables Kafka transactions. It is only supported by
+	// NewTransactionalConsumer, which drives the transactions itself; the
+	// other producer constructors reject it.
+	TransactionalID string
+
+	// DisableIdempotence turns off the idempotent producer. Idempotence
+	// requires RequiredAcks to be WaitForAll and at most one open request.
+	DisableIdempotence bool
+	// MaxOpenRequests is the number of in-flight requests per broker
+	// connection. Zero means 1 for idempotent producers and sarama's default otherwise.
+	MaxOpenRequests int
+
+	// Partitioner selects the partition for each message. Nil uses sarama's
+	// hash partitioner, which keeps messages with the same key in order.
+	Partitioner sarama.PartitionerConstructor
+
+	// Breaker configures the circuit breaker; zero fields use DefaultBreakerConfig
+	Breaker BreakerConfig
+	// OnStateChange is called on every circuit breaker transition
+	OnStateChange func(name string, from, to gobreaker.State)
+
+	// Security configures TLS and SASL; the zero value connects in plaintext
+	Security SecurityConfig
+
+	// Spool, when set, durably buffers messages on local disk while the
+	// circuit breaker is open and replays them in order once it closes.
+	// Only Publish and PublishMessage use the spool.
+	Spool *SpoolConfig
+
+	// MeterProvider receives publish duration, message and breaker state metrics; nil uses the global provider
+	MeterProvider metric.MeterProvider
+
+	// Topics and Routes resolve the topics of PublishEvent; nil uses
+	// events.DefaultTopicConfig and events.DefaultRoutes
+	Topics *events.TopicConfig
+	Routes events.Routes
+}
+
+// BreakerConfig holds circuit breaker thresholds
+type BreakerConfig struct {
+	// MaxRequests is the number of trial requests allowed while half-open
+	MaxRequests uint32
+	// Interval is the period after which closed-state counts are cleared
+	Interval time.Duration
+	// Timeout is how long the breaker stays open before going half-open
+	Timeout time.Duration
+	// MinRequests is the number of requests in an interval before the breaker can trip
+	MinRequests uint32
+	// FailureRatio is the fraction of failed requests that trips the breaker
+	FailureRatio float64
+}
+
+// DefaultBreakerConfig returns the default circuit breaker thresholds
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		MaxRequests:  3,
+		Interval:     10 * time.Second,
+		Timeout:      30 * time.Second,
+		MinRequests:  3,
+		FailureRatio: 0.6,
+	}
 }
 
 // DefaultProducerConfig returns sensible defaults for banking operations
@@ -36,7 +102,54 @@ func DefaultProducerConfig(brokers []string, clientID string) ProducerConfig {
 		FlushFrequency:  100 * time.Millisecond,
 		FlushMessages:   100,
 		CompressionType: sarama.CompressionGZIP,
+		Breaker:         DefaultBreakerConfig(),
+	}
+}
+
+// validateNonTransactional validates cfg for producers that do not drive transactions
+func (cfg ProducerConfig) validateNonTransactional() error {
+	if cfg.TransactionalID != "" {
+		return errors.New("transactional ID is only supported by NewTransactionalConsumer")
+	}
+	return cfg.Validate()
+}
+
+// Validate checks the configuration for incompatible settings
+func (cfg ProducerConfig) Validate() error {
+	if len(cfg.Brokers) == 0 {
+		return errors.New("at least one broker is required")
+	}
+	if !cfg.DisableIdempotence {
+		if cfg.RequiredAcks != sarama.WaitForAll {
+			return errors.New("idempotent producer requires RequiredAcks to be WaitForAll")
+		}
+		if cfg.MaxOpenRequests > 1 {
+			return errors.New("idempotent producer requires MaxOpenRequests to be 1")
+		}
+		if cfg.RetryMax < 1 {
+			return errors.New("idempotent producer requires RetryMax >= 1")
+		}
+	} else if cfg.TransactionalID != "" {
+		return errors.New("transactional producer requires idempotence")
+	}
+	if cfg.MaxOpenRequests < 0 {
+		return errors.New("MaxOpenRequests must not be negative")
+	}
+	if r := cfg.Breaker.FailureRatio; r < 0 || r > 1 {
+		return fmt.Errorf("breaker failure ratio %v must be between 0 and 1", r)
+	}
+	if cfg.Spool != nil && cfg.Spool.Dir == "" {
+		return errors.New("spool requires a directory")
+	}
+	if cfg.Topics != nil {
+		if err := cfg.Topics.Validate(); err != nil {
+			return fmt.Errorf("invalid topic config: %w", err)
+		}
+	}
+	if err := cfg.Routes.Validate(); err != nil {
+		return fmt.Errorf("invalid routes: %w", err)
 	}
+	return nil
 }
 
 // Producer is a resilient Kafka producer with circuit breaker
@@ -45,10 +158,101 @@ type Producer struct {
 	cb       *gobreaker.CircuitBreaker
 	logger   *zap.Logger
 	tracer   trace.Tracer
+	metrics  *producerMetrics
+	topics   events.TopicConfig
+	routes   events.Routes
+
+	spool  *spool
+	replay chan struct{} // wakes the replay loop, e.g. when the breaker closes
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // NewProducer creates a new Kafka producer with circuit breaker
 func NewProducer(cfg ProducerConfig, logger *zap.Logger) (*Producer, error) {
+	if err := cfg.validateNonTransactional(); err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+
+	config, err := newSaramaProducerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka producer: %w", err)
+	}
+
+	p, err := newProducer(cfg, producer, logger)
+	if err != nil {
+		_ = producer.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewProducerFromClient creates a producer that publishes through an existing
+// sarama producer, e.g. an in-memory one in tests. Close closes producer.
+func NewProducerFromClient(cfg ProducerConfig, producer sarama.SyncProducer, logger *zap.Logger) (*Producer, error) {
+	if err := cfg.validateNonTransactional(); err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+	return newProducer(cfg, producer, logger)
+}
+
+// newProducer wraps a sarama producer with the breaker, spool and metrics of cfg
+func newProducer(cfg ProducerConfig, producer sarama.SyncProducer, logger *zap.Logger) (*Producer, error) {
+	p := &Producer{
+		producer: producer,
+		logger:   logger,
+		tracer:   otel.Tracer("banking-shared/kafka"),
+		topics:   events.DefaultTopicConfig(),
+		routes:   cfg.Routes,
+	}
+	if cfg.Topics != nil {
+		p.topics = *cfg.Topics
+	}
+	if p.routes == nil {
+		p.routes = events.DefaultRoutes()
+	}
+
+	settings := newBreakerSettings("kafka-producer", cfg, logger)
+	if cfg.Spool != nil {
+		s, err := openSpool(*cfg.Spool, logger)
+		if err != nil {
+			return nil, err
+		}
+		onStateChange := settings.OnStateChange
+		settings.OnStateChange = func(name string, from, to gobreaker.State) {
+			onStateChange(name, from, to)
+			if to != gobreaker.StateOpen {
+				p.wakeReplay()
+			}
+		}
+		p.spool = s
+	}
+	p.cb = gobreaker.NewCircuitBreaker(settings)
+
+	var err error
+	if p.metrics, err = newProducerMetrics(cfg.MeterProvider, settings.Name, p.cb.State); err != nil {
+		if p.spool != nil {
+			_ = p.spool.close()
+		}
+		return nil, err
+	}
+
+	if p.spool != nil {
+		p.startReplay()
+	}
+	return p, nil
+}
+
+// newSaramaProducerConfig translates a ProducerConfig into a sarama config
+func newSaramaProducerConfig(cfg ProducerConfig) (*sarama.Config, error) {
 	config := sarama.NewConfig()
 	config.ClientID = cfg.ClientID
 	config.Producer.RequiredAcks = cfg.RequiredAcks
@@ -59,24 +263,78 @@ func NewProducer(cfg ProducerConfig, logger *zap.Logger) (*Producer, error) {
 	config.Producer.Return.Successes = true
 	config.Producer.Return.Errors = true
 
-	// Enable idempotent producer for exactly-once semantics
-	config.Producer.Idempotent = true
-	config.Net.MaxOpenRequests = 1
+	// Idempotent producer for exactly-once semantics; requires a single open request
+	config.Producer.Idempotent = !cfg.DisableIdempotence
+	if cfg.MaxOpenRequests > 0 {
+		config.Net.MaxOpenRequests = cfg.MaxOpenRequests
+	} else if config.Producer.Idempotent {
+		config.Net.MaxOpenRequests = 1
+	}
 
-	producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create kafka producer: %w", err)
+	if cfg.Partitioner != nil {
+		config.Producer.Partitioner = cfg.Partitioner
 	}
 
-	// Configure circuit breaker
-	cbSettings := gobreaker.Settings{
-		Name:        "kafka-producer",
-		MaxRequests: 3,
-		Interval:    10 * time.Second,
-		Timeout:     30 * time.Second,
+	if cfg.TransactionalID != "" {
+		config.Producer.Transaction.ID = cfg.TransactionalID
+	}
+
+	if err := cfg.Security.apply(config); err != nil {
+		return nil, fmt.Errorf("invalid security config: %w", err)
+	}
+
+	return config, nil
+}
+
+// permanentPublishError reports whether the broker or client will reject the
+// message on every attempt, so retrying it would block the spool forever
+func permanentPublishError(err error) bool {
+	var configErr sarama.ConfigurationError
+	return errors.As(err, &configErr) ||
+		errors.Is(err, sarama.ErrMessageSizeTooLarge) ||
+		errors.Is(err, sarama.ErrInvalidMessage) ||
+		errors.Is(err, sarama.ErrInvalidRecord) ||
+		errors.Is(err, sarama.ErrInvalidTopic) ||
+		errors.Is(err, sarama.ErrUnknownTopicOrPartition) ||
+		errors.Is(err, sarama.ErrTopicAuthorizationFailed)
+}
+
+// breakerSuccess counts permanent publish failures as successes: they are
+// caused by the message, not by an unhealthy cluster
+func breakerSuccess(err error) bool {
+	return err == nil || permanentPublishError(err)
+}
+
+// newBreakerSettings translates the breaker config into gobreaker settings,
+// filling zero fields from DefaultBreakerConfig
+func newBreakerSettings(name string, cfg ProducerConfig, logger *zap.Logger) gobreaker.Settings {
+	bc := cfg.Breaker
+	defaults := DefaultBreakerConfig()
+	if bc.MaxRequests == 0 {
+		bc.MaxRequests = defaults.MaxRequests
+	}
+	if bc.Interval == 0 {
+		bc.Interval = defaults.Interval
+	}
+	if bc.Timeout == 0 {
+		bc.Timeout = defaults.Timeout
+	}
+	if bc.MinRequests == 0 {
+		bc.MinRequests = defaults.MinRequests
+	}
+	if bc.FailureRatio == 0 {
+		bc.FailureRatio = defaults.FailureRatio
+	}
+
+	return gobreaker.Settings{
+		Name:         name,
+		MaxRequests:  bc.MaxRequests,
+		Interval:     bc.Interval,
+		Timeout:      bc.Timeout,
+		IsSuccessful: breakerSuccess,
 		ReadyToTrip: func(counts gobreaker.Counts) bool {
 			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
-			return counts.Requests >= 3 && failureRatio >= 0.6
+			return counts.Requests >= bc.MinRequests && failureRatio >= bc.FailureRatio
 		},
 		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
 			logger.Warn("Circuit breaker state change",
@@ -84,15 +342,11 @@ func NewProducer(cfg ProducerConfig, logger *zap.Logger) (*Producer, error) {
 				zap.String("from", from.String()),
 				zap.String("to", to.String()),
 			)
+			if cfg.OnStateChange != nil {
+				cfg.OnStateChange(name, from, to)
+			}
 		},
 	}
-
-	return &Producer{
-		producer: producer,
-		cb:       gobreaker.NewCircuitBreaker(cbSettings),
-		logger:   logger,
-		tracer:   otel.Tracer("banking-shared/kafka"),
-	}, nil
 }
 
 // Event interface for publishable events
@@ -102,11 +356,7 @@ type Event interface {
 
 // Publish sends an event to Kafka with circuit breaker protection
 func (p *Producer) Publish(ctx context.Context, topic string, event Event) error {
-	ctx, span := p.tracer.Start(ctx, "kafka.publish",
-		trace.WithAttributes(
-			attribute.String("kafka.topic", topic),
-		),
-	)
+	ctx, span := p.startPublishSpan(ctx, topic)
 	defer span.End()
 
 	payload, err := json.Marshal(event)
@@ -121,56 +371,214 @@ func (p *Producer) Publish(ctx context.Context, topic string, event Event) error
 		Value: sarama.ByteEncoder(payload),
 		Headers: []sarama.RecordHeader{
 			{Key: []byte("content-type"), Value: []byte("application/json")},
-			{Key: []byte("trace-id"), Value: []byte(span.SpanContext().TraceID().String())},
 		},
 	}
 
-	_, err = p.cb.Execute(func() (interface{}, error) {
+	return p.send(ctx, span, msg)
+}
+
+// PublishMessage sends a pre-built message to Kafka with circuit breaker protection.
+// It is used to republish raw consumer messages, e.g. to dead-letter topics.
+func (p *Producer) PublishMessage(ctx context.Context, msg *sarama.ProducerMessage) error {
+	ctx, span := p.startPublishSpan(ctx, msg.Topic)
+	defer span.End()
+
+	return p.send(ctx, span, msg)
+}
+
+// startPublishSpan starts a producer span with messaging semantic-convention attributes
+func (p *Producer) startPublishSpan(ctx context.Context, topic string) (context.Context, trace.Span) {
+	return p.tracer.Start(ctx, "kafka.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(topic),
+		),
+	)
+}
+
+// send injects the trace context into the headers and sends the message through the circuit breaker
+func (p *Producer) send(ctx context.Context, span trace.Span, msg *sarama.ProducerMessage) error {
+	propagator().Inject(ctx, producerCarrier{msg})
+	// Legacy header kept for consumers that predate W3C trace context propagation
+	msg.Headers = setHeader(msg.Headers, "trace-id", span.SpanContext().TraceID().String())
+
+	if p.spool != nil && p.spool.pending() {
+		// Keep order behind messages still waiting in the spool
+		return p.spoolMessage(ctx, span, msg)
+	}
+
+	start := time.Now()
+	_, err := p.cb.Execute(func() (interface{}, error) {
 		partition, offset, err := p.producer.SendMessage(msg)
 		if err != nil {
 			return nil, err
 		}
+		span.SetAttributes(
+			semconv.MessagingKafkaDestinationPartition(int(partition)),
+			semconv.MessagingKafkaMessageOffset(int(offset)),
+		)
 		p.logger.Debug("Message sent",
-			zap.String("topic", topic),
+			zap.String("topic", msg.Topic),
 			zap.Int32("partition", partition),
 			zap.Int64("offset", offset),
 		)
 		return nil, nil
 	})
+	if err != nil {
+		p.metrics.recordPublish(ctx, msg.Topic, start, 0, 1, err)
+	} else {
+		p.metrics.recordPublish(ctx, msg.Topic, start, 1, 0, nil)
+	}
+
+	if p.spool != nil && (errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)) {
+		return p.spoolMessage(ctx, span, msg)
+	}
 
 	if err != nil {
 		span.RecordError(err)
 		p.logger.Error("Failed to publish message",
-			zap.String("topic", topic),
+			zap.String("topic", msg.Topic),
 			zap.Error(err),
 		)
-		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+		return fmt.Errorf("failed to publish to %s: %w", msg.Topic, err)
 	}
 
 	return nil
 }
 
-// PublishBatch sends multiple events to Kafka
-func (p *Producer) PublishBatch(ctx context.Context, topic string, events []Event) error {
+// setHeader sets a header value, replacing any existing header with the same key
+func setHeader(headers []sarama.RecordHeader, key, value string) []sarama.RecordHeader {
+	for i := range headers {
+		if string(headers[i].Key) == key {
+			headers[i].Value = []byte(value)
+			return headers
+		}
+	}
+	return append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+// PublishBatch sends multiple events to Kafka in a single request and reports
+// the outcome of every event. The batch is not atomic: see BatchResult for the
+// partial-failure semantics. The returned error is non-nil if any event failed.
+func (p *Producer) PublishBatch(ctx context.Context, topic string, events []Event) (*BatchResult, error) {
 	ctx, span := p.tracer.Start(ctx, "kafka.publish_batch",
+		trace.WithSpanKind(trace.SpanKindProducer),
 		trace.WithAttributes(
-			attribute.String("kafka.topic", topic),
-			attribute.Int("batch_size", len(events)),
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingBatchMessageCount(len(events)),
 		),
 	)
 	defer span.End()
 
-	for _, event := range events {
-		if err := p.Publish(ctx, topic, event); err != nil {
-			return err
+	result := &BatchResult{Results: make([]PublishResult, len(events))}
+	msgs := make([]*sarama.ProducerMessage, 0, len(events))
+	index := make(map[*sarama.ProducerMessage]int, len(events))
+
+	for i, event := range events {
+		result.Results[i] = PublishResult{Topic: topic, Partition: -1, Offset: -1}
+
+		payload, err := json.Marshal(event)
+		if err != nil {
+			result.Results[i].Err = fmt.Errorf("failed to marshal event: %w", err)
+			continue
+		}
+
+		msg := &sarama.ProducerMessage{
+			Topic: topic,
+			Key:   sarama.StringEncoder(event.Key()),
+			Value: sarama.ByteEncoder(payload),
+			Headers: []sarama.RecordHeader{
+				{Key: []byte("content-type"), Value: []byte("application/json")},
+			},
+			// Overwritten by the producer on success; used to detect unsent messages
+			Offset: -1,
 		}
+		propagator().Inject(ctx, producerCarrier{msg})
+		msg.Headers = setHeader(msg.Headers, "trace-id", span.SpanContext().TraceID().String())
+
+		index[msg] = i
+		msgs = append(msgs, msg)
 	}
-	return nil
+
+	if len(msgs) > 0 && p.spool != nil && p.spool.pending() {
+		// Keep order behind messages still waiting in the spool
+		for _, msg := range msgs {
+			if err := p.spoolMessage(ctx, span, msg); err != nil {
+				result.Results[index[msg]].Err = err
+			}
+		}
+	} else if len(msgs) > 0 {
+		start := time.Now()
+		_, sendErr := p.cb.Execute(func() (interface{}, error) {
+			return nil, p.producer.SendMessages(msgs)
+		})
+		sent := 0
+		defer func() {
+			p.metrics.recordPublish(ctx, topic, start, sent, len(msgs)-sent, sendErr)
+		}()
+
+		failed := make(map[*sarama.ProducerMessage]error)
+		var producerErrs sarama.ProducerErrors
+		if errors.As(sendErr, &producerErrs) {
+			for _, perr := range producerErrs {
+				failed[perr.Msg] = perr.Err
+			}
+		}
+
+		for _, msg := range msgs {
+			res := &result.Results[index[msg]]
+			err, ok := failed[msg]
+			if !ok && sendErr != nil && msg.Offset < 0 {
+				// Breaker open or a request-level error: the message was not written
+				err, ok = sendErr, true
+			}
+			if ok {
+				res.Err = fmt.Errorf("failed to publish to %s: %w", topic, err)
+				continue
+			}
+			res.Partition = msg.Partition
+			res.Offset = msg.Offset
+			sent++
+		}
+	}
+
+	if err := result.Err(); err != nil {
+		span.RecordError(err)
+		p.logger.Error("Failed to publish batch",
+			zap.String("topic", topic),
+			zap.Int("batch_size", len(events)),
+			zap.Int("failed", len(result.Failed())),
+			zap.Error(err),
+		)
+		return result, err
+	}
+	return result, nil
 }
 
-// Close closes the producer
+// Close stops spool replay and closes the producer. Records still in the
+// spool are kept on disk and replayed by the next producer using the same
+// directory. Calling Close again returns the result of the first call.
 func (p *Producer) Close() error {
-	return p.producer.Close()
+	p.closeOnce.Do(func() {
+		if p.spool != nil {
+			if p.stop != nil {
+				close(p.stop)
+			}
+			p.wg.Wait()
+			if err := p.spool.close(); err != nil {
+				p.logger.Error("Failed to close spool", zap.Error(err))
+			}
+		}
+		if err := p.metrics.unregister(); err != nil {
+			p.logger.Error("Failed to unregister producer metrics", zap.Error(err))
+		}
+		p.closeErr = p.producer.Close()
+	})
+	return p.closeErr
 }
 
 // IsHealthy returns true if the circuit breaker is closed
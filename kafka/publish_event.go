@@ -0,0 +1,110 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+)
+
+// Headers stamped on messages published by PublishEvent and the outbox relay
+const (
+	HeaderEventType     = "event-type"
+	HeaderEventID       = "event-id"
+	HeaderEventVersion  = "event-version"
+	HeaderCorrelationID = "correlation-id"
+	HeaderSource        = "source"
+)
+
+// RoutedEvent is an event that embeds events.BaseEvent, so that its topic is
+// resolved from its event type
+type RoutedEvent interface {
+	Event
+	Base() *events.BaseEvent
+}
+
+type correlationContextKey struct{}
+
+// ContextWithCorrelationID returns a context carrying a correlation ID for
+// events published with PublishEvent
+func ContextWithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationContextKey{}, correlationID)
+}
+
+// CorrelationIDFromContext returns the correlation ID carried by ctx. Handlers
+// of a Consumer receive the correlation-id header of their message.
+func CorrelationIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationContextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// contextWithMessageCorrelation carries the correlation-id header of msg, if any
+func contextWithMessageCorrelation(ctx context.Context, msg *sarama.ConsumerMessage) context.Context {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == HeaderCorrelationID && len(h.Value) > 0 {
+			return ContextWithCorrelationID(ctx, string(h.Value))
+		}
+	}
+	return ctx
+}
+
+// PublishEvent publishes event to every topic its event type is routed to
+// by the producer's Routes and Topics, stamped with the event-type, event-id,
+// event-version, correlation-id and source headers. If the event has no
+// correlation ID, it is set from ctx before publishing.
+//
+// A fanned-out event that fails on a later topic has already been published
+// to the earlier ones; retries should rely on consumers deduplicating by event ID.
+func (p *Producer) PublishEvent(ctx context.Context, event RoutedEvent) error {
+	base := event.Base()
+	if base.EventType == "" {
+		return errors.New("event has no event_type")
+	}
+	topics, err := p.routes.Topics(base.EventType, p.topics)
+	if err != nil {
+		return fmt.Errorf("failed to route event: %w", err)
+	}
+
+	if base.CorrelationID == "" {
+		if id, ok := CorrelationIDFromContext(ctx); ok {
+			base.CorrelationID = id
+		}
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	for _, topic := range topics {
+		msg := &sarama.ProducerMessage{
+			Topic:   topic,
+			Key:     sarama.StringEncoder(event.Key()),
+			Value:   sarama.ByteEncoder(payload),
+			Headers: EventHeaders(base),
+		}
+		if err := p.PublishMessage(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EventHeaders returns the standard headers of an event: content-type,
+// event-type, event-id, event-version, source and, if set, correlation-id
+func EventHeaders(base *events.BaseEvent) []sarama.RecordHeader {
+	headers := []sarama.RecordHeader{
+		{Key: []byte("content-type"), Value: []byte("application/json")},
+		{Key: []byte(HeaderEventType), Value: []byte(base.EventType)},
+		{Key: []byte(HeaderEventID), Value: []byte(base.EventID.String())},
+		{Key: []byte(HeaderEventVersion), Value: []byte(base.Version)},
+		{Key: []byte(HeaderSource), Value: []byte(base.Source)},
+	}
+	if base.CorrelationID != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(HeaderCorrelationID), Value: []byte(base.CorrelationID)})
+	}
+	return headers
+}
@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/banking/shared/events"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// newRoutingProducer returns a producer built from cfg on a mock that records sent messages
+func newRoutingProducer(t *testing.T, cfg ProducerConfig, sends int) (*Producer, *[]*sarama.ProducerMessage) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	mockProducer := mocks.NewSyncProducer(t, config)
+	sent := &[]*sarama.ProducerMessage{}
+	for i := 0; i < sends; i++ {
+		mockProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			*sent = append(*sent, msg)
+			return nil
+		})
+	}
+
+	p, err := NewProducerFromClient(cfg, mockProducer, zaptest.NewLogger(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+	return p, sent
+}
+
+func TestProducer_PublishEvent(t *testing.T) {
+	p, sent := newRoutingProducer(t, DefaultProducerConfig([]string{"localhost:9092"}, "test"), 1)
+
+	event := &events.TransactionInitiatedEvent{
+		BaseEvent: events.NewBaseEvent(events.EventTypeTransactionInitiated, "transfer-service"),
+		UserID:    uuid.New(),
+	}
+	ctx := ContextWithCorrelationID(context.Background(), "req-42")
+	require.NoError(t, p.PublishEvent(ctx, event))
+
+	require.Len(t, *sent, 1)
+	msg := (*sent)[0]
+	assert.Equal(t, "banking.transactions.initiated", msg.Topic)
+	key, _ := msg.Key.Encode()
+	assert.Equal(t, event.UserID.String(), string(key))
+
+	headers := headerMap(msg.Headers)
+	assert.Equal(t, "application/json", headers["content-type"])
+	assert.Equal(t, "TransactionInitiated", headers[HeaderEventType])
+	assert.Equal(t, event.EventID.String(), headers[HeaderEventID])
+	assert.Equal(t, "1.0", headers[HeaderEventVersion])
+	assert.Equal(t, "req-42", headers[HeaderCorrelationID])
+	assert.Equal(t, "transfer-service", headers[HeaderSource])
+
+	assert.Equal(t, "req-42", event.CorrelationID, "correlation ID is filled in from the context")
+	value, _ := msg.Value.Encode()
+	var published events.BaseEvent
+	require.NoError(t, json.Unmarshal(value, &published))
+	assert.Equal(t, "req-42", published.CorrelationID)
+}
+
+func TestProducer_PublishEvent_KeepsCorrelationAndFansOut(t *testing.T) {
+	topics := events.DefaultTopicConfig().WithPrefix("staging")
+	cfg := DefaultProducerConfig([]string{"localhost:9092"}, "test")
+	cfg.Topics = &topics
+	cfg.Routes = events.DefaultRoutes()
+	cfg.Routes[events.EventTypeUserCreated] = []events.TopicKey{events.TopicUserEvents, events.TopicAuditLog}
+	p, sent := newRoutingProducer(t, cfg, 2)
+
+	event := &events.UserCreatedEvent{
+		BaseEvent: events.NewBaseEvent(events.EventTypeUserCreated, "user-service").WithCorrelation("signup-7"),
+		UserID:    uuid.New(),
+	}
+	require.NoError(t, p.PublishEvent(ContextWithCorrelationID(context.Background(), "req-42"), event))
+
+	require.Len(t, *sent, 2)
+	assert.Equal(t, "staging.banking.users.events", (*sent)[0].Topic)
+	assert.Equal(t, "staging.banking.audit.log", (*sent)[1].Topic)
+	for _, msg := range *sent {
+		assert.Equal(t, "signup-7", headerMap(msg.Headers)[HeaderCorrelationID], "an existing correlation ID is kept")
+	}
+}
+
+func TestProducerConfig_ValidateRoutes(t *testing.T) {
+	cfg := DefaultProducerConfig([]string{"localhost:9092"}, "test")
+	cfg.Routes = events.Routes{events.EventTypeUserCreated: {"users"}}
+	assert.ErrorContains(t, cfg.Validate(), "invalid routes")
+
+	topics := events.DefaultTopicConfig()
+	topics.AuditLog = ""
+	cfg = DefaultProducerConfig([]string{"localhost:9092"}, "test")
+	cfg.Topics = &topics
+	assert.ErrorContains(t, cfg.Validate(), "invalid topic config")
+}
+
+func TestProducer_PublishEvent_Unrouted(t *testing.T) {
+	p, sent := newRoutingProducer(t, DefaultProducerConfig([]string{"localhost:9092"}, "test"), 0)
+
+	event := &events.TransactionInitiatedEvent{BaseEvent: events.NewBaseEvent("TransferScheduled", "transfer-service")}
+	assert.ErrorIs(t, p.PublishEvent(context.Background(), event), events.ErrUnrouted)
+
+	event.EventType = ""
+	assert.ErrorContains(t, p.PublishEvent(context.Background(), event), "no event_type")
+	assert.Empty(t, *sent)
+}
+
+func TestConsumer_HandlerReceivesCorrelationID(t *testing.T) {
+	var got string
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		got, _ = CorrelationIDFromContext(ctx)
+		return nil
+	}, nil)
+
+	msg := testMessage(1)
+	msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(HeaderCorrelationID), Value: []byte("req-42")})
+	done, err := c.process(context.Background(), msg)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "req-42", got)
+}
@@ -0,0 +1,390 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+)
+
+// ErrGroupActive is returned when offsets would be reset for a consumer group that has members
+var ErrGroupActive = errors.New("consumer group has active members")
+
+// ErrReplayIncomplete is returned by Replay for partitions that stopped
+// receiving messages before their end offset, or whose handler failed on a
+// message without a dead-letter policy. Trailing transaction markers or
+// aborted records of transactional producers also cause it, as they are never
+// delivered; the error names each partition and the offset it stopped at.
+var ErrReplayIncomplete = errors.New("replay stopped before the end offset")
+
+// defaultReplayIdleTimeout is the ConsumerConfig.ReplayIdleTimeout default
+const defaultReplayIdleTimeout = 5 * time.Second
+
+// StartPosition selects where a replay starts reading each partition.
+// Exactly one of Time, Offsets or Earliest must be set.
+type StartPosition struct {
+	// Time starts at the first message with a timestamp at or after Time;
+	// partitions with no such message start at the end
+	Time time.Time
+	// Offsets starts at explicit offsets by topic and partition; partitions
+	// that are not listed are left untouched
+	Offsets map[string]map[int32]int64
+	// Earliest starts at the oldest retained message
+	Earliest bool
+}
+
+// FromTime returns a StartPosition at the first message at or after t
+func FromTime(t time.Time) StartPosition {
+	return StartPosition{Time: t}
+}
+
+// FromOffsets returns a StartPosition at explicit offsets
+func FromOffsets(offsets map[string]map[int32]int64) StartPosition {
+	return StartPosition{Offsets: offsets}
+}
+
+// FromEarliest returns a StartPosition at the oldest retained message
+func FromEarliest() StartPosition {
+	return StartPosition{Earliest: true}
+}
+
+// validate checks that exactly one position is set
+func (p StartPosition) validate() error {
+	set := 0
+	if !p.Time.IsZero() {
+		set++
+	}
+	if p.Offsets != nil {
+		set++
+	}
+	if p.Earliest {
+		set++
+	}
+	if set != 1 {
+		return errors.New("start position requires exactly one of time, offsets or earliest")
+	}
+	for topic, partitions := range p.Offsets {
+		for partition, offset := range partitions {
+			if offset < 0 {
+				return fmt.Errorf("invalid offset %d for %s/%d", offset, topic, partition)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveOffsets returns the offset pos refers to for every partition of topics
+func resolveOffsets(client sarama.Client, topics []string, pos StartPosition) (map[string]map[int32]int64, error) {
+	if pos.Offsets != nil {
+		return pos.Offsets, nil
+	}
+
+	target := sarama.OffsetOldest
+	if !pos.Time.IsZero() {
+		target = pos.Time.UnixMilli()
+	}
+
+	offsets := make(map[string]map[int32]int64, len(topics))
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+		}
+		offsets[topic] = make(map[int32]int64, len(partitions))
+		for _, partition := range partitions {
+			offset, err := client.GetOffset(topic, partition, target)
+			if err == nil && offset == -1 {
+				// No message at or after the timestamp
+				offset, err = client.GetOffset(topic, partition, sarama.OffsetNewest)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+			}
+			offsets[topic][partition] = offset
+		}
+	}
+	return offsets, nil
+}
+
+// newReplayClient creates a client for cfg that reports errors through the returned values only
+func newReplayClient(cfg ConsumerConfig) (sarama.Client, error) {
+	config, err := newSaramaConsumerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	config.Consumer.Return.Errors = false
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	// Outputs of aborted transactions are not replayed
+	config.Consumer.IsolationLevel = sarama.ReadCommitted
+
+	client, err := sarama.NewClient(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka client: %w", err)
+	}
+	return client, nil
+}
+
+// ResetGroupOffsets commits the offsets at pos for every partition of
+// cfg.Topics in consumer group cfg.GroupID and returns them. It fails with
+// ErrGroupActive unless the group is empty, so a running consumer is never rewound.
+//
+// The group is checked before and after the commit, but Kafka cannot make
+// the check and the commit atomic: a member that joins in between may start
+// from the old offsets or overwrite the reset with its own commits. The check
+// after the commit reports that case with ErrGroupActive; stop every consumer
+// of the group, or use a dedicated group ID, before resetting.
+func ResetGroupOffsets(ctx context.Context, cfg ConsumerConfig, pos StartPosition, logger *zap.Logger) (map[string]map[int32]int64, error) {
+	if err := pos.validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := newReplayClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to create kafka admin: %w", err)
+	}
+	// Closing the admin closes the client
+	defer admin.Close()
+
+	offsets, err := resetGroupOffsets(ctx, client, admin, cfg.GroupID, cfg.Topics, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Reset consumer group offsets",
+		zap.String("group_id", cfg.GroupID),
+		zap.Any("offsets", offsets),
+	)
+	return offsets, nil
+}
+
+func resetGroupOffsets(ctx context.Context, client sarama.Client, admin sarama.ClusterAdmin, groupID string, topics []string, pos StartPosition) (map[string]map[int32]int64, error) {
+	if err := ensureGroupInactive(admin, groupID); err != nil {
+		return nil, err
+	}
+
+	offsets, err := resolveOffsets(client, topics, pos)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	om, err := sarama.NewOffsetManagerFromClient(groupID, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offset manager: %w", err)
+	}
+	defer om.Close()
+
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			pom, err := om.ManagePartition(topic, partition)
+			if err != nil {
+				return nil, fmt.Errorf("failed to manage offsets of %s/%d: %w", topic, partition, err)
+			}
+			// ResetOffset only moves backwards and MarkOffset only forwards
+			pom.ResetOffset(offset, "")
+			pom.MarkOffset(offset, "")
+		}
+	}
+	om.Commit()
+
+	// A member that joined during the reset may not see the new offsets
+	if err := ensureGroupInactive(admin, groupID); err != nil {
+		return nil, fmt.Errorf("group joined while resetting offsets: %w", err)
+	}
+	if err := verifyGroupOffsets(admin, groupID, offsets); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// ensureGroupInactive fails with ErrGroupActive if the group has members
+func ensureGroupInactive(admin sarama.ClusterAdmin, groupID string) error {
+	groups, err := admin.DescribeConsumerGroups([]string{groupID})
+	if err != nil {
+		return fmt.Errorf("failed to describe consumer group %s: %w", groupID, err)
+	}
+	for _, group := range groups {
+		if group.Err != sarama.ErrNoError {
+			return fmt.Errorf("failed to describe consumer group %s: %w", groupID, group.Err)
+		}
+		if len(group.Members) > 0 || (group.State != "Empty" && group.State != "Dead") {
+			return fmt.Errorf("%w: %s is %s with %d members", ErrGroupActive, groupID, group.State, len(group.Members))
+		}
+	}
+	return nil
+}
+
+// verifyGroupOffsets checks that the group's committed offsets match offsets
+func verifyGroupOffsets(admin sarama.ClusterAdmin, groupID string, offsets map[string]map[int32]int64) error {
+	partitions := make(map[string][]int32, len(offsets))
+	for topic, ps := range offsets {
+		for partition := range ps {
+			partitions[topic] = append(partitions[topic], partition)
+		}
+	}
+
+	resp, err := admin.ListConsumerGroupOffsets(groupID, partitions)
+	if err != nil {
+		return fmt.Errorf("failed to fetch committed offsets: %w", err)
+	}
+	for topic, ps := range offsets {
+		for partition, want := range ps {
+			block := resp.GetBlock(topic, partition)
+			if block == nil || block.Err != sarama.ErrNoError || block.Offset != want {
+				return fmt.Errorf("failed to commit offset %d for %s/%d", want, topic, partition)
+			}
+		}
+	}
+	return nil
+}
+
+// NewReplayConsumer resets the offsets of cfg.GroupID to pos and returns a
+// consumer that starts from there. The group must not have members; use a
+// dedicated group ID to reprocess without disturbing the live consumers.
+func NewReplayConsumer(ctx context.Context, cfg ConsumerConfig, pos StartPosition, handler MessageHandler, logger *zap.Logger) (*Consumer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if _, err := ResetGroupOffsets(ctx, cfg, pos, logger); err != nil {
+		return nil, fmt.Errorf("failed to reset offsets for replay: %w", err)
+	}
+	return NewConsumer(cfg, handler, logger)
+}
+
+// Replay processes every partition of cfg.Topics from pos up to the end
+// offset at the time of the call, without joining a consumer group or
+// committing offsets. Partitions are replayed concurrently and in order
+// within each partition, with the retry and dead-letter policies of cfg.
+// Partitions that receive no message for cfg.ReplayIdleTimeout before their
+// end offset are reported with ErrReplayIncomplete.
+func Replay(ctx context.Context, cfg ConsumerConfig, pos StartPosition, handler MessageHandler, logger *zap.Logger) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if err := pos.validate(); err != nil {
+		return err
+	}
+
+	client, err := newReplayClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	metrics, err := newConsumerMetrics(cfg.MeterProvider, cfg.GroupID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := metrics.unregister(); err != nil {
+			logger.Error("Failed to unregister replay metrics", zap.Error(err))
+		}
+	}()
+	c := newConsumer(cfg, nil, handler, metrics, logger)
+	return c.replay(ctx, client, pos)
+}
+
+func (c *Consumer) replay(ctx context.Context, client sarama.Client, pos StartPosition) error {
+	offsets, err := resolveOffsets(client, c.topics, pos)
+	if err != nil {
+		return err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to create kafka consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for topic, partitions := range offsets {
+		for partition, start := range partitions {
+			end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return fmt.Errorf("failed to get end offset of %s/%d: %w", topic, partition, err)
+			}
+			if start >= end {
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := c.replayPartition(ctx, consumer, topic, partition, start, end); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// replayPartition processes messages in [start, end) of one partition
+func (c *Consumer) replayPartition(ctx context.Context, consumer sarama.Consumer, topic string, partition int32, start, end int64) error {
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s/%d from %d: %w", topic, partition, start, err)
+	}
+	defer pc.AsyncClose()
+
+	c.logger.Info("Replaying partition",
+		zap.String("topic", topic),
+		zap.Int32("partition", partition),
+		zap.Int64("start_offset", start),
+		zap.Int64("end_offset", end),
+	)
+
+	last := start - 1
+	idle := time.NewTimer(c.replayIdle)
+	defer idle.Stop()
+	for {
+		select {
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				return nil
+			}
+			done, err := c.process(ctx, msg)
+			if err != nil {
+				return fmt.Errorf("failed to replay %s/%d at %d: %w", topic, partition, msg.Offset, err)
+			}
+			if !done {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				// Failed without a dead-letter policy; later offsets must not be replayed past it
+				return fmt.Errorf("%w: %s/%d failed at offset %d, end offset %d",
+					ErrReplayIncomplete, topic, partition, msg.Offset, end)
+			}
+			last = msg.Offset
+			if msg.Offset+1 >= end {
+				return nil
+			}
+			idle.Reset(c.replayIdle)
+
+		case <-idle.C:
+			return fmt.Errorf("%w: %s/%d idle for %s after offset %d, end offset %d",
+				ErrReplayIncomplete, topic, partition, c.replayIdle, last, end)
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
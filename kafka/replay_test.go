@@ -0,0 +1,206 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+const replayTopic = "banking.transactions.completed"
+
+// newReplayBroker returns a broker serving replayTopic with two partitions:
+// partition 0 holds offsets 0-9 and partition 1 offsets 0-5
+func newReplayBroker(t *testing.T, groups sarama.MockResponse, committed *sarama.MockOffsetFetchResponse) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(replayHandlers(t, broker, groups, committed))
+	return broker
+}
+
+func replayHandlers(t *testing.T, broker *sarama.MockBroker, groups sarama.MockResponse, committed *sarama.MockOffsetFetchResponse) map[string]sarama.MockResponse {
+
+	fetch := sarama.NewMockFetchResponse(t, 10).
+		SetHighWaterMark(replayTopic, 0, 10).
+		SetHighWaterMark(replayTopic, 1, 6)
+	for offset := int64(0); offset < 10; offset++ {
+		fetch.SetMessage(replayTopic, 0, offset, sarama.StringEncoder(`{}`))
+	}
+
+	return map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(replayTopic, 0, broker.BrokerID()).
+			SetLeader(replayTopic, 1, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(replayTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(replayTopic, 0, sarama.OffsetNewest, 10).
+			SetOffset(replayTopic, 0, 1700000000000, 4).
+			SetOffset(replayTopic, 1, sarama.OffsetOldest, 0).
+			SetOffset(replayTopic, 1, sarama.OffsetNewest, 6).
+			SetOffset(replayTopic, 1, 1700000000000, -1),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "replay-group", broker),
+		"DescribeGroupsRequest": groups,
+		"OffsetFetchRequest":    committed,
+		"OffsetCommitRequest":   sarama.NewMockOffsetCommitResponse(t),
+		"FetchRequest":          fetch,
+	}
+}
+
+func replayConfig(broker *sarama.MockBroker) ConsumerConfig {
+	return ConsumerConfig{
+		Brokers: []string{broker.Addr()},
+		GroupID: "replay-group",
+		Topics:  []string{replayTopic},
+	}
+}
+
+func TestStartPosition_Validate(t *testing.T) {
+	assert.NoError(t, FromTime(time.Now()).validate())
+	assert.NoError(t, FromEarliest().validate())
+	assert.NoError(t, FromOffsets(map[string]map[int32]int64{replayTopic: {0: 3}}).validate())
+
+	assert.Error(t, StartPosition{}.validate())
+	assert.Error(t, StartPosition{Time: time.Now(), Earliest: true}.validate())
+	assert.Error(t, FromOffsets(map[string]map[int32]int64{replayTopic: {0: -1}}).validate())
+}
+
+func TestResetGroupOffsets_FromTime(t *testing.T) {
+	committed := sarama.NewMockOffsetFetchResponse(t).
+		SetOffset("replay-group", replayTopic, 0, 4, "", sarama.ErrNoError).
+		SetOffset("replay-group", replayTopic, 1, 6, "", sarama.ErrNoError)
+	broker := newReplayBroker(t, sarama.NewMockDescribeGroupsResponse(t), committed)
+
+	offsets, err := ResetGroupOffsets(context.Background(), replayConfig(broker), FromTime(time.UnixMilli(1700000000000)), zaptest.NewLogger(t))
+	require.NoError(t, err)
+	// Partition 1 has no message after the timestamp and starts at the end
+	assert.Equal(t, map[string]map[int32]int64{replayTopic: {0: 4, 1: 6}}, offsets)
+
+	var commits []int64
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+			for partition := int32(0); partition < 2; partition++ {
+				if offset, _, err := req.Offset(replayTopic, partition); err == nil {
+					commits = append(commits, offset)
+				}
+			}
+		}
+	}
+	assert.ElementsMatch(t, []int64{4, 6}, commits)
+}
+
+func TestResetGroupOffsets_RefusesActiveGroup(t *testing.T) {
+	groups := sarama.NewMockDescribeGroupsResponse(t).
+		AddGroupDescription("replay-group", &sarama.GroupDescription{
+			GroupId: "replay-group",
+			State:   "Stable",
+			Members: map[string]*sarama.GroupMemberDescription{"member-1": {}},
+		})
+	broker := newReplayBroker(t, groups, sarama.NewMockOffsetFetchResponse(t))
+
+	_, err := ResetGroupOffsets(context.Background(), replayConfig(broker), FromEarliest(), zaptest.NewLogger(t))
+	require.ErrorIs(t, err, ErrGroupActive)
+
+	for _, rr := range broker.History() {
+		_, ok := rr.Request.(*sarama.OffsetCommitRequest)
+		assert.False(t, ok, "no offsets are committed for an active group")
+	}
+}
+
+func TestResetGroupOffsets_ReportsGroupJoinedDuringReset(t *testing.T) {
+	active := sarama.NewMockDescribeGroupsResponse(t).
+		AddGroupDescription("replay-group", &sarama.GroupDescription{
+			GroupId: "replay-group",
+			State:   "PreparingRebalance",
+			Members: map[string]*sarama.GroupMemberDescription{"member-1": {}},
+		})
+	groups := sarama.NewMockSequence(sarama.NewMockDescribeGroupsResponse(t), active)
+	committed := sarama.NewMockOffsetFetchResponse(t).
+		SetOffset("replay-group", replayTopic, 0, 0, "", sarama.ErrNoError).
+		SetOffset("replay-group", replayTopic, 1, 0, "", sarama.ErrNoError)
+	broker := newReplayBroker(t, groups, committed)
+
+	_, err := ResetGroupOffsets(context.Background(), replayConfig(broker), FromEarliest(), zaptest.NewLogger(t))
+	require.ErrorIs(t, err, ErrGroupActive)
+	assert.ErrorContains(t, err, "joined while resetting")
+}
+
+func TestReplay_ReportsPartitionsIdleBeforeEndOffset(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	handlers := replayHandlers(t, broker, sarama.NewMockDescribeGroupsResponse(t), sarama.NewMockOffsetFetchResponse(t))
+	// Offsets 10 and 11 of partition 0 are never delivered
+	handlers["OffsetRequest"] = sarama.NewMockOffsetResponse(t).
+		SetOffset(replayTopic, 0, sarama.OffsetOldest, 0).
+		SetOffset(replayTopic, 0, sarama.OffsetNewest, 12).
+		SetOffset(replayTopic, 1, sarama.OffsetOldest, 0).
+		SetOffset(replayTopic, 1, sarama.OffsetNewest, 6)
+	broker.SetHandlerByMap(handlers)
+
+	cfg := replayConfig(broker)
+	cfg.ReplayIdleTimeout = time.Second
+	handled := 0
+	handler := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		handled++
+		return nil
+	}
+
+	pos := FromOffsets(map[string]map[int32]int64{replayTopic: {0: 7, 1: 6}})
+	err := Replay(context.Background(), cfg, pos, handler, zaptest.NewLogger(t))
+	require.ErrorIs(t, err, ErrReplayIncomplete)
+	assert.ErrorContains(t, err, replayTopic+"/0 idle for 1s after offset 9, end offset 12")
+	assert.Equal(t, 3, handled)
+}
+
+func TestReplay_ProcessesUpToEndOffset(t *testing.T) {
+	broker := newReplayBroker(t, sarama.NewMockDescribeGroupsResponse(t), sarama.NewMockOffsetFetchResponse(t))
+
+	var (
+		mu      sync.Mutex
+		offsets []int64
+	)
+	handler := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		offsets = append(offsets, msg.Offset)
+		return nil
+	}
+
+	// Partition 1 already starts at its end and is skipped
+	pos := FromOffsets(map[string]map[int32]int64{replayTopic: {0: 7, 1: 6}})
+	require.NoError(t, Replay(context.Background(), replayConfig(broker), pos, handler, zaptest.NewLogger(t)))
+	assert.Equal(t, []int64{7, 8, 9}, offsets)
+}
+
+func TestReplay_ReportsFailedMessageWithoutDeadLetter(t *testing.T) {
+	broker := newReplayBroker(t, sarama.NewMockDescribeGroupsResponse(t), sarama.NewMockOffsetFetchResponse(t))
+
+	var (
+		mu      sync.Mutex
+		offsets []int64
+	)
+	handler := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		offsets = append(offsets, msg.Offset)
+		if msg.Offset == 8 {
+			return NonRetryable(errors.New("bad payload"))
+		}
+		return nil
+	}
+
+	pos := FromOffsets(map[string]map[int32]int64{replayTopic: {0: 7, 1: 6}})
+	err := Replay(context.Background(), replayConfig(broker), pos, handler, zaptest.NewLogger(t))
+	require.ErrorIs(t, err, ErrReplayIncomplete)
+	assert.ErrorContains(t, err, replayTopic+"/0 failed at offset 8, end offset 10")
+	assert.Equal(t, []int64{7, 8}, offsets)
+}
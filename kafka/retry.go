@@ -0,0 +1,187 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+)
+
+// Headers stamped on messages moved to a retry tier
+const (
+	HeaderRetryTier              = "retry-tier"
+	HeaderRetryAttempts          = "retry-attempts"
+	HeaderRetryNotBefore         = "retry-not-before"
+	HeaderRetryError             = "retry-error"
+	HeaderRetryOriginalTopic     = "retry-original-topic"
+	HeaderRetryOriginalPartition = "retry-original-partition"
+	HeaderRetryOriginalOffset    = "retry-original-offset"
+)
+
+// RetryGroupSuffix is appended to the consumer group ID for retry consumers
+const RetryGroupSuffix = ".retry"
+
+// RetryPolicy schedules failed messages onto delayed retry topics
+// (<topic>.retry.1m, <topic>.retry.10m, ...) so the main partition is never blocked.
+type RetryPolicy struct {
+	// Producer publishes messages to the retry topics (circuit-broken and traced)
+	Producer *Producer
+	// Tiers is the delay before each successive retry; tier N is consumed from <topic>.retry.<Tiers[N-1]>
+	Tiers []time.Duration
+}
+
+// DefaultRetryPolicy returns sensible retry tiers for banking consumers
+func DefaultRetryPolicy(producer *Producer) *RetryPolicy {
+	return &RetryPolicy{
+		Producer: producer,
+		Tiers:    []time.Duration{time.Minute, 10 * time.Minute, time.Hour},
+	}
+}
+
+// Topic returns the retry topic for a source topic at the given tier (1-based)
+func (p *RetryPolicy) Topic(topic string, tier int) string {
+	return topic + ".retry." + tierName(p.Tiers[tier-1])
+}
+
+// Topics returns every retry topic for the given source topics
+func (p *RetryPolicy) Topics(topics []string) []string {
+	out := make([]string, 0, len(topics)*len(p.Tiers))
+	for _, topic := range topics {
+		for tier := range p.Tiers {
+			out = append(out, p.Topic(topic, tier+1))
+		}
+	}
+	return out
+}
+
+// next returns the tier a failed message should move to, if any remain
+func (p *RetryPolicy) next(msg *sarama.ConsumerMessage) (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+	tier := headerInt(msg, HeaderRetryTier)
+	if tier >= len(p.Tiers) {
+		return 0, false
+	}
+	return tier + 1, true
+}
+
+// publish moves the message to the given retry tier with scheduling metadata
+func (p *RetryPolicy) publish(ctx context.Context, msg *sarama.ConsumerMessage, tier int, cause error, attempts int) error {
+	topic, partition, offset := origin(msg)
+	delay := p.Tiers[tier-1]
+
+	headers := copyHeaders(msg.Headers)
+	headers = setHeader(headers, HeaderRetryTier, strconv.Itoa(tier))
+	headers = setHeader(headers, HeaderRetryAttempts, strconv.Itoa(attempts))
+	headers = setHeader(headers, HeaderRetryNotBefore, time.Now().Add(delay).UTC().Format(time.RFC3339Nano))
+	headers = setHeader(headers, HeaderRetryError, cause.Error())
+	headers = setHeader(headers, HeaderRetryOriginalTopic, topic)
+	headers = setHeader(headers, HeaderRetryOriginalPartition, strconv.FormatInt(int64(partition), 10))
+	headers = setHeader(headers, HeaderRetryOriginalOffset, strconv.FormatInt(offset, 10))
+
+	out := &sarama.ProducerMessage{
+		Topic:   p.Topic(topic, tier),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		out.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	return p.Producer.PublishMessage(ctx, out)
+}
+
+// NewRetryConsumer creates a consumer for the retry tiers of cfg.Topics.
+// It joins the group <GroupID>.retry, waits until each message is due, and
+// runs the same handler; messages that fail again move to the next tier and,
+// once the tiers are exhausted, to the dead-letter topic if one is configured.
+func NewRetryConsumer(cfg ConsumerConfig, handler MessageHandler, logger *zap.Logger) (*Consumer, error) {
+	if cfg.Retry == nil || len(cfg.Retry.Tiers) == 0 {
+		return nil, errors.New("retry consumer requires at least one retry tier")
+	}
+
+	retryCfg := cfg
+	retryCfg.Topics = cfg.Retry.Topics(cfg.Topics)
+	retryCfg.GroupID = cfg.GroupID + RetryGroupSuffix
+
+	c, err := NewConsumer(retryCfg, handler, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retry consumer: %w", err)
+	}
+	c.delayed = true
+	return c, nil
+}
+
+// origin returns the topic, partition and offset a message was first consumed from
+func origin(msg *sarama.ConsumerMessage) (string, int32, int64) {
+	topic := headerValue(msg, HeaderRetryOriginalTopic)
+	if topic == "" {
+		return msg.Topic, msg.Partition, msg.Offset
+	}
+	partition, _ := strconv.ParseInt(headerValue(msg, HeaderRetryOriginalPartition), 10, 32)
+	offset, _ := strconv.ParseInt(headerValue(msg, HeaderRetryOriginalOffset), 10, 64)
+	return topic, int32(partition), offset
+}
+
+// notBefore returns when a retried message becomes due
+func notBefore(msg *sarama.ConsumerMessage) (time.Time, bool) {
+	v := headerValue(msg, HeaderRetryNotBefore)
+	if v == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// tierName formats a tier delay compactly for use in topic names (30s, 1m, 10m, 1h)
+func tierName(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d >= time.Minute && d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	case d >= time.Second && d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	default:
+		return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+	}
+}
+
+// headerValue returns the value of the first header with the given key
+func headerValue(msg *sarama.ConsumerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// headerInt returns the integer value of a header, or zero if absent or malformed
+func headerInt(msg *sarama.ConsumerMessage, key string) int {
+	n, err := strconv.Atoi(headerValue(msg, key))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// copyHeaders converts consumer headers into producer headers
+func copyHeaders(in []*sarama.RecordHeader) []sarama.RecordHeader {
+	out := make([]sarama.RecordHeader, 0, len(in)+8)
+	for _, h := range in {
+		if h == nil {
+			continue
+		}
+		out = append(out, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_Topics(t *testing.T) {
+	p := &RetryPolicy{Tiers: []time.Duration{30 * time.Second, time.Minute, 10 * time.Minute, 2 * time.Hour}}
+
+	assert.Equal(t, []string{
+		"banking.notifications.retry.30s",
+		"banking.notifications.retry.1m",
+		"banking.notifications.retry.10m",
+		"banking.notifications.retry.2h",
+	}, p.Topics([]string{"banking.notifications"}))
+}
+
+func TestConsumer_ConsumeClaim_ScheduleRetry(t *testing.T) {
+	producer, mockProducer := newTestProducer(t)
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return errors.New("core banking unavailable")
+	}, nil)
+	c.retry = DefaultRetryPolicy(producer)
+	session := newFakeSession(context.Background())
+
+	var published *sarama.ProducerMessage
+	mockProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		published = msg
+		return nil
+	})
+
+	before := time.Now()
+	err := c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(5)))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{5}, session.markedOffsets())
+
+	require.NotNil(t, published)
+	assert.Equal(t, "banking.transactions.initiated.retry.1m", published.Topic)
+
+	headers := headerMap(published.Headers)
+	assert.Equal(t, "1", headers[HeaderRetryTier])
+	assert.Equal(t, "1", headers[HeaderRetryAttempts])
+	assert.Equal(t, "core banking unavailable", headers[HeaderRetryError])
+	assert.Equal(t, "banking.transactions.initiated", headers[HeaderRetryOriginalTopic])
+	assert.Equal(t, "2", headers[HeaderRetryOriginalPartition])
+	assert.Equal(t, "5", headers[HeaderRetryOriginalOffset])
+
+	due, err := time.Parse(time.RFC3339Nano, headers[HeaderRetryNotBefore])
+	require.NoError(t, err)
+	assert.WithinDuration(t, before.Add(time.Minute), due, 5*time.Second)
+}
+
+func TestRetryConsumer_ExhaustedTiersDeadLetter(t *testing.T) {
+	producer, mockProducer := newTestProducer(t)
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return errors.New("still failing")
+	}, &DeadLetterPolicy{Producer: producer})
+	c.retry = &RetryPolicy{Producer: producer, Tiers: []time.Duration{time.Minute, 10 * time.Minute}}
+	c.delayed = true
+	session := newFakeSession(context.Background())
+
+	msg := testMessage(42)
+	msg.Topic = "banking.transactions.initiated.retry.10m"
+	msg.Partition = 0
+	msg.Headers = append(msg.Headers,
+		&sarama.RecordHeader{Key: []byte(HeaderRetryTier), Value: []byte("2")},
+		&sarama.RecordHeader{Key: []byte(HeaderRetryAttempts), Value: []byte("2")},
+		&sarama.RecordHeader{Key: []byte(HeaderRetryNotBefore), Value: []byte(time.Now().Add(20 * time.Millisecond).UTC().Format(time.RFC3339Nano))},
+		&sarama.RecordHeader{Key: []byte(HeaderRetryOriginalTopic), Value: []byte("banking.transactions.initiated")},
+		&sarama.RecordHeader{Key: []byte(HeaderRetryOriginalPartition), Value: []byte("2")},
+		&sarama.RecordHeader{Key: []byte(HeaderRetryOriginalOffset), Value: []byte("7")},
+	)
+
+	var published *sarama.ProducerMessage
+	mockProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		published = msg
+		return nil
+	})
+
+	err := c.ConsumeClaim(session, newFakeClaim(msg.Topic, 0, msg))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{42}, session.markedOffsets())
+
+	require.NotNil(t, published)
+	assert.Equal(t, "banking.transactions.initiated.dlq", published.Topic)
+	headers := headerMap(published.Headers)
+	assert.Equal(t, "3", headers[HeaderDLQAttempts])
+	assert.Equal(t, "banking.transactions.initiated", headers[HeaderDLQOriginalTopic])
+	assert.Equal(t, "2", headers[HeaderDLQOriginalPartition])
+	assert.Equal(t, "7", headers[HeaderDLQOriginalOffset])
+}
+
+func TestRetryConsumer_WaitsUntilDue(t *testing.T) {
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		t.Fatal("handler must not run before the retry is due")
+		return nil
+	}, nil)
+	c.delayed = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	session := newFakeSession(ctx)
+
+	msg := testMessage(1)
+	msg.Headers = append(msg.Headers, &sarama.RecordHeader{
+		Key:   []byte(HeaderRetryNotBefore),
+		Value: []byte(time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)),
+	})
+
+	err := c.ConsumeClaim(session, newFakeClaim(msg.Topic, msg.Partition, msg))
+	require.NoError(t, err)
+	assert.Empty(t, session.markedOffsets())
+}
@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"go.uber.org/zap"
+)
+
+// Router dispatches messages to typed handlers keyed by event type.
+// Its Handle method is a MessageHandler usable with NewConsumer.
+type Router struct {
+	handlers map[events.EventType]MessageHandler
+	fallback MessageHandler
+	logger   *zap.Logger
+}
+
+// NewRouter creates an empty event router
+func NewRouter(logger *zap.Logger) *Router {
+	return &Router{
+		handlers: make(map[events.EventType]MessageHandler),
+		logger:   logger,
+	}
+}
+
+// On registers a typed handler for an event type. The message value is decoded
+// into T before fn is called; decode failures are returned as non-retryable
+// errors. Registering the same event type twice panics.
+func On[T any](r *Router, eventType events.EventType, fn func(ctx context.Context, event *T) error) {
+	if _, exists := r.handlers[eventType]; exists {
+		panic(fmt.Sprintf("kafka: handler already registered for event type %s", eventType))
+	}
+
+	r.handlers[eventType] = func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		var event T
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			return NonRetryable(fmt.Errorf("failed to decode %s event: %w", eventType, err))
+		}
+		return fn(ctx, &event)
+	}
+}
+
+// Fallback sets the handler for event types with no registered handler.
+// Without a fallback, unknown event types are logged and skipped.
+func (r *Router) Fallback(handler MessageHandler) {
+	r.fallback = handler
+}
+
+// Handle dispatches a message to the handler registered for its event type
+func (r *Router) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var envelope struct {
+		EventType events.EventType `json:"event_type"`
+	}
+	if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+		return NonRetryable(fmt.Errorf("failed to decode event envelope: %w", err))
+	}
+
+	if handler, ok := r.handlers[envelope.EventType]; ok {
+		return handler(ctx, msg)
+	}
+
+	if r.fallback != nil {
+		return r.fallback(ctx, msg)
+	}
+
+	r.logger.Debug("No handler for event type",
+		zap.String("event_type", string(envelope.EventType)),
+		zap.String("topic", msg.Topic),
+		zap.Int32("partition", msg.Partition),
+		zap.Int64("offset", msg.Offset),
+	)
+	return nil
+}
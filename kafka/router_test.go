@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestRouter_DispatchesTypedEvent(t *testing.T) {
+	router := NewRouter(zaptest.NewLogger(t))
+	userID := uuid.New()
+
+	var received *events.TransactionInitiatedEvent
+	On(router, events.EventTypeTransactionInitiated, func(ctx context.Context, evt *events.TransactionInitiatedEvent) error {
+		received = evt
+		return nil
+	})
+
+	msg := &sarama.ConsumerMessage{
+		Value: []byte(`{"event_type":"TransactionInitiated","user_id":"` + userID.String() + `","currency":"USD"}`),
+	}
+
+	require.NoError(t, router.Handle(context.Background(), msg))
+	require.NotNil(t, received)
+	assert.Equal(t, userID, received.UserID)
+	assert.Equal(t, "USD", received.Currency)
+}
+
+func TestRouter_DecodeFailureIsNonRetryable(t *testing.T) {
+	router := NewRouter(zaptest.NewLogger(t))
+	On(router, events.EventTypeTransactionInitiated, func(ctx context.Context, evt *events.TransactionInitiatedEvent) error {
+		return nil
+	})
+
+	err := router.Handle(context.Background(), &sarama.ConsumerMessage{
+		Value: []byte(`{"event_type":"TransactionInitiated","user_id":42}`),
+	})
+	require.Error(t, err)
+	assert.True(t, IsNonRetryable(err))
+
+	err = router.Handle(context.Background(), &sarama.ConsumerMessage{Value: []byte(`not json`)})
+	require.Error(t, err)
+	assert.True(t, IsNonRetryable(err))
+}
+
+func TestRouter_UnknownEventType(t *testing.T) {
+	router := NewRouter(zaptest.NewLogger(t))
+	msg := &sarama.ConsumerMessage{Value: []byte(`{"event_type":"UserCreated"}`)}
+
+	assert.NoError(t, router.Handle(context.Background(), msg))
+
+	fallbackErr := errors.New("unexpected event")
+	router.Fallback(func(ctx context.Context, m *sarama.ConsumerMessage) error {
+		return fallbackErr
+	})
+	assert.ErrorIs(t, router.Handle(context.Background(), msg), fallbackErr)
+}
+
+func TestRouter_DuplicateRegistrationPanics(t *testing.T) {
+	router := NewRouter(zaptest.NewLogger(t))
+	fn := func(ctx context.Context, evt *events.UserCreatedEvent) error { return nil }
+	On(router, events.EventTypeUserCreated, fn)
+
+	assert.Panics(t, func() {
+		On(router, events.EventTypeUserCreated, fn)
+	})
+}
+
+func TestConsumer_NonRetryableSkipsRetries(t *testing.T) {
+	producer, mockProducer := newTestProducer(t)
+	policy := DefaultDeadLetterPolicy(producer)
+
+	calls := 0
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		calls++
+		return NonRetryable(errors.New("malformed payload"))
+	}, policy)
+	c.retry = DefaultRetryPolicy(producer)
+	session := newFakeSession(context.Background())
+
+	var published *sarama.ProducerMessage
+	mockProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		published = msg
+		return nil
+	})
+
+	require.NoError(t, c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(9))))
+	assert.Equal(t, 1, calls)
+	require.NotNil(t, published)
+	assert.Equal(t, "banking.transactions.initiated.dlq", published.Topic)
+	assert.Equal(t, []int64{9}, session.markedOffsets())
+}
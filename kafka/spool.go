@@ -0,0 +1,677 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// ErrSpoolFull is returned when a message does not fit in the spool and the
+// spool is configured to reject rather than block
+var ErrSpoolFull = errors.New("kafka spool is full")
+
+// SpoolFullPolicy controls what Publish does when the spool reaches MaxBytes
+type SpoolFullPolicy int
+
+const (
+	// SpoolFullReject fails the publish with ErrSpoolFull
+	SpoolFullReject SpoolFullPolicy = iota
+	// SpoolFullBlock blocks the publish until replay frees space or its context is done
+	SpoolFullBlock
+)
+
+const (
+	spoolSegmentExt = ".spool"
+	spoolCursorFile = "cursor"
+	// spoolHeaderSize is the record length and CRC-32C preceding each record
+	spoolHeaderSize = 8
+)
+
+var spoolCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SpoolConfig configures the local spool that buffers messages while the
+// producer's circuit breaker is open
+type SpoolConfig struct {
+	// Dir holds the spool segment files; it must not be shared between producers
+	Dir string
+	// MaxBytes bounds the size of unreplayed records; zero means 256 MiB
+	MaxBytes int64
+	// SegmentBytes is the size at which a new segment file is started; zero means 16 MiB
+	SegmentBytes int64
+	// FullPolicy selects rejecting or blocking publishes when the spool is full
+	FullPolicy SpoolFullPolicy
+	// ReplayInterval is how often replay is attempted while records are
+	// pending; zero means 1s. Replay also starts when the breaker closes.
+	ReplayInterval time.Duration
+	// OnReplay is called after each replay attempt that sent, dropped or
+	// failed records. Records dropped after a permanent publish failure are
+	// reported in err and counted in SpoolStats.Dropped.
+	OnReplay func(replayed int, stats SpoolStats, err error)
+}
+
+// SpoolStats is a snapshot of the spool's size and counters
+type SpoolStats struct {
+	// Records and Bytes are waiting to be replayed
+	Records int
+	Bytes   int64
+	// Spooled, Replayed and Rejected count records since the spool was opened
+	Spooled  uint64
+	Replayed uint64
+	Rejected uint64
+	// Dropped counts records removed because the broker can never accept them
+	Dropped uint64
+}
+
+func (c SpoolConfig) withDefaults() SpoolConfig {
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = 256 << 20
+	}
+	if c.SegmentBytes <= 0 {
+		c.SegmentBytes = 16 << 20
+	}
+	if c.ReplayInterval <= 0 {
+		c.ReplayInterval = time.Second
+	}
+	return c
+}
+
+// spoolRecord is the serialized form of a spooled message
+type spoolRecord struct {
+	Topic   string                `json:"topic"`
+	Key     []byte                `json:"key,omitempty"`
+	Value   []byte                `json:"value,omitempty"`
+	Headers []sarama.RecordHeader `json:"headers,omitempty"`
+}
+
+// spool is an append-only log of messages split into segment files. Each
+// record is framed by its length and CRC and fsynced before append returns.
+// Records are replayed in order by a single reader; fully replayed segments
+// are deleted and the read position is kept in a cursor file.
+type spool struct {
+	cfg    SpoolConfig
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	space     chan struct{} // closed and replaced whenever replay frees space
+	segments  []uint64      // segment IDs in order; the last one is appended to
+	nextID    uint64
+	writer    *os.File
+	writeSize int64
+	reader    *os.File
+	readOff   int64 // offset of the next record to replay in segments[0]
+	headSize  int64 // size of the record returned by next
+	stats     SpoolStats
+}
+
+// openSpool opens the spool in cfg.Dir, recovering records left by a previous run
+func openSpool(cfg SpoolConfig, logger *zap.Logger) (*spool, error) {
+	cfg = cfg.withDefaults()
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &spool{cfg: cfg, logger: logger, space: make(chan struct{})}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover loads the segment list and cursor and validates every pending
+// record, truncating a segment at its first torn or corrupt record
+func (s *spool) recover() error {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), spoolSegmentExt)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, n)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	cursorSeg, cursorOff := s.readCursor()
+	// Segments before the cursor were replayed but not yet deleted
+	for len(s.segments) > 0 && s.segments[0] < cursorSeg {
+		_ = os.Remove(s.segmentPath(s.segments[0]))
+		s.segments = s.segments[1:]
+	}
+
+	for i, id := range s.segments {
+		valid, offsets, err := scanSegment(s.segmentPath(id))
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(s.segmentPath(id)); err == nil && info.Size() > valid {
+			s.logger.Error("Truncating corrupt spool segment",
+				zap.String("segment", s.segmentPath(id)),
+				zap.Int64("valid_bytes", valid),
+				zap.Int64("dropped_bytes", info.Size()-valid),
+			)
+			if err := os.Truncate(s.segmentPath(id), valid); err != nil {
+				return fmt.Errorf("failed to truncate spool segment: %w", err)
+			}
+		}
+
+		start := int64(0)
+		if i == 0 && id == cursorSeg && (offsets[cursorOff] || cursorOff == valid) {
+			start = cursorOff
+			s.readOff = cursorOff
+		}
+		for off := range offsets {
+			if off >= start && off < valid {
+				s.stats.Records++
+			}
+		}
+		s.stats.Bytes += valid - start
+		if i == len(s.segments)-1 {
+			s.writeSize = valid
+		}
+	}
+
+	if len(s.segments) > 0 {
+		s.nextID = s.segments[len(s.segments)-1] + 1
+		if s.stats.Records == 0 {
+			return s.reset()
+		}
+		w, err := os.OpenFile(s.segmentPath(s.segments[len(s.segments)-1]), os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open spool segment: %w", err)
+		}
+		s.writer = w
+	}
+	return nil
+}
+
+// scanSegment returns the length of the segment's valid prefix and the offsets of its records
+func scanSegment(path string) (int64, map[int64]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	offsets := make(map[int64]bool)
+	var off int64
+	for {
+		_, n, err := readSpoolRecord(f, off)
+		if err != nil {
+			// io.EOF at a record boundary is the clean end; anything else is a torn or corrupt tail
+			return off, offsets, nil
+		}
+		offsets[off] = true
+		off += n
+	}
+}
+
+// append writes msg to the spool and fsyncs it. When the spool is full it
+// rejects or waits for space according to the FullPolicy.
+func (s *spool) append(ctx context.Context, msg *sarama.ProducerMessage) error {
+	data, err := encodeSpoolRecord(msg)
+	if err != nil {
+		return err
+	}
+	size := int64(spoolHeaderSize + len(data))
+	if size > s.cfg.MaxBytes {
+		return fmt.Errorf("message of %d bytes exceeds spool size: %w", size, ErrSpoolFull)
+	}
+
+	s.mu.Lock()
+	for s.stats.Bytes+size > s.cfg.MaxBytes {
+		if s.cfg.FullPolicy != SpoolFullBlock {
+			s.stats.Rejected++
+			s.mu.Unlock()
+			return ErrSpoolFull
+		}
+		space := s.space
+		s.mu.Unlock()
+		select {
+		case <-space:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for spool space: %w", ctx.Err())
+		}
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+
+	if s.writer == nil || (s.writeSize > 0 && s.writeSize+size > s.cfg.SegmentBytes) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(data, spoolCRCTable))
+	copy(buf[spoolHeaderSize:], data)
+
+	if _, err := s.writer.Write(buf); err != nil {
+		// Drop the partial record so the next append starts at a record boundary
+		_ = s.writer.Truncate(s.writeSize)
+		return fmt.Errorf("failed to write spool record: %w", err)
+	}
+	if err := s.writer.Sync(); err != nil {
+		_ = s.writer.Truncate(s.writeSize)
+		return fmt.Errorf("failed to sync spool segment: %w", err)
+	}
+
+	s.writeSize += size
+	s.stats.Records++
+	s.stats.Bytes += size
+	s.stats.Spooled++
+	return nil
+}
+
+// rotate starts a new segment for appends
+func (s *spool) rotate() error {
+	id := s.nextID
+	f, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	// Records synced to a segment whose directory entry is lost would vanish on a crash
+	if err := s.syncDir(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(s.segmentPath(id))
+		return err
+	}
+	if s.writer != nil {
+		_ = s.writer.Close()
+	}
+	s.writer = f
+	s.writeSize = 0
+	s.segments = append(s.segments, id)
+	s.nextID++
+	return nil
+}
+
+// pending reports whether records are waiting to be replayed. While it is
+// true new messages must be spooled too, so that they are not published
+// ahead of older spooled messages.
+func (s *spool) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats.Records > 0
+}
+
+// next returns the oldest record without removing it; it returns nil when
+// the spool is empty. Only the replay goroutine may call next and ack.
+func (s *spool) next() (*sarama.ProducerMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.stats.Records > 0 {
+		if s.reader == nil {
+			f, err := os.Open(s.segmentPath(s.segments[0]))
+			if err != nil {
+				return nil, fmt.Errorf("failed to open spool segment: %w", err)
+			}
+			s.reader = f
+		}
+
+		rec, n, err := readSpoolRecord(s.reader, s.readOff)
+		if errors.Is(err, io.EOF) && len(s.segments) > 1 {
+			// Segment fully replayed; move on to the next one
+			_ = s.reader.Close()
+			s.reader = nil
+			_ = os.Remove(s.segmentPath(s.segments[0]))
+			s.segments = s.segments[1:]
+			s.readOff = 0
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool record: %w", err)
+		}
+
+		s.headSize = n
+		return rec.message(), nil
+	}
+	return nil, nil
+}
+
+// ack removes the record returned by next after it has been published
+func (s *spool) ack() error {
+	return s.remove(&s.stats.Replayed)
+}
+
+// drop removes the record returned by next after a permanent publish failure
+func (s *spool) drop() error {
+	return s.remove(&s.stats.Dropped)
+}
+
+// remove advances past the record returned by next and increments counter
+func (s *spool) remove(counter *uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readOff += s.headSize
+	s.stats.Records--
+	s.stats.Bytes -= s.headSize
+	*counter++
+	s.headSize = 0
+
+	close(s.space)
+	s.space = make(chan struct{})
+
+	if s.stats.Records == 0 {
+		return s.reset()
+	}
+	return s.writeCursor()
+}
+
+// reset deletes all segments once every record has been replayed
+func (s *spool) reset() error {
+	if s.reader != nil {
+		_ = s.reader.Close()
+		s.reader = nil
+	}
+	if s.writer != nil {
+		_ = s.writer.Close()
+		s.writer = nil
+	}
+	for _, id := range s.segments {
+		if err := os.Remove(s.segmentPath(id)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove spool segment: %w", err)
+		}
+	}
+	s.segments = nil
+	s.readOff = 0
+	s.writeSize = 0
+	if err := os.Remove(filepath.Join(s.cfg.Dir, spoolCursorFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove spool cursor: %w", err)
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the spool's size and counters
+func (s *spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.reader != nil {
+		err = s.reader.Close()
+		s.reader = nil
+	}
+	if s.writer != nil {
+		if werr := s.writer.Close(); werr != nil && err == nil {
+			err = werr
+		}
+		s.writer = nil
+	}
+	return err
+}
+
+func (s *spool) segmentPath(id uint64) string {
+	return filepath.Join(s.cfg.Dir, fmt.Sprintf("%020d%s", id, spoolSegmentExt))
+}
+
+// readCursor returns the persisted read position, or zeros if there is none.
+// A lost cursor only causes records to be replayed again.
+func (s *spool) readCursor() (uint64, int64) {
+	data, err := os.ReadFile(filepath.Join(s.cfg.Dir, spoolCursorFile))
+	if err != nil {
+		return 0, 0
+	}
+	seg, off, ok := strings.Cut(strings.TrimSpace(string(data)), " ")
+	if !ok {
+		return 0, 0
+	}
+	id, err := strconv.ParseUint(seg, 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+	offset, err := strconv.ParseInt(off, 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+	return id, offset
+}
+
+// writeCursor atomically replaces the cursor file with the current read position
+func (s *spool) writeCursor() error {
+	path := filepath.Join(s.cfg.Dir, spoolCursorFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write spool cursor: %w", err)
+	}
+	_, err = fmt.Fprintf(f, "%d %d\n", s.segments[0], s.readOff)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write spool cursor: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write spool cursor: %w", err)
+	}
+	return s.syncDir()
+}
+
+// syncDir fsyncs the spool directory so created and renamed files survive a crash
+func (s *spool) syncDir() error {
+	dir, err := os.Open(s.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to open spool directory: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool directory: %w", err)
+	}
+	return nil
+}
+
+// readSpoolRecord reads the record at off and returns it with its framed size
+func readSpoolRecord(r io.ReaderAt, off int64) (*spoolRecord, int64, error) {
+	var header [spoolHeaderSize]byte
+	if _, err := r.ReadAt(header[:], off); err != nil {
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, off+spoolHeaderSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.Checksum(data, spoolCRCTable) != checksum {
+		return nil, 0, errors.New("spool record checksum mismatch")
+	}
+
+	var rec spoolRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode spool record: %w", err)
+	}
+	return &rec, spoolHeaderSize + int64(length), nil
+}
+
+func encodeSpoolRecord(msg *sarama.ProducerMessage) ([]byte, error) {
+	rec := spoolRecord{Topic: msg.Topic, Headers: msg.Headers}
+	var err error
+	if msg.Key != nil {
+		if rec.Key, err = msg.Key.Encode(); err != nil {
+			return nil, fmt.Errorf("failed to encode message key: %w", err)
+		}
+	}
+	if msg.Value != nil {
+		if rec.Value, err = msg.Value.Encode(); err != nil {
+			return nil, fmt.Errorf("failed to encode message value: %w", err)
+		}
+	}
+	return json.Marshal(rec)
+}
+
+func (r *spoolRecord) message() *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{Topic: r.Topic, Headers: r.Headers}
+	if r.Key != nil {
+		msg.Key = sarama.ByteEncoder(r.Key)
+	}
+	if r.Value != nil {
+		msg.Value = sarama.ByteEncoder(r.Value)
+	}
+	return msg
+}
+
+// spoolMessage appends a message to the spool for later replay
+func (p *Producer) spoolMessage(ctx context.Context, span trace.Span, msg *sarama.ProducerMessage) error {
+	if err := p.spool.append(ctx, msg); err != nil {
+		span.RecordError(err)
+		p.logger.Error("Failed to spool message",
+			zap.String("topic", msg.Topic),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to spool message for %s: %w", msg.Topic, err)
+	}
+
+	span.AddEvent("spooled")
+	p.logger.Debug("Message spooled", zap.String("topic", msg.Topic))
+	return nil
+}
+
+func (p *Producer) startReplay() {
+	p.replay = make(chan struct{}, 1)
+	p.stop = make(chan struct{})
+	p.wg.Add(1)
+	go p.replayLoop()
+}
+
+// wakeReplay triggers a replay attempt without waiting for the next interval
+func (p *Producer) wakeReplay() {
+	select {
+	case p.replay <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Producer) replayLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.spool.cfg.ReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.replay:
+		case <-p.stop:
+			return
+		}
+		p.replaySpool()
+	}
+}
+
+// replaySpool publishes spooled messages in order until the spool is empty or
+// a publish fails; the failed message is retried on the next attempt. A
+// message that fails permanently is dropped so later messages can proceed.
+func (p *Producer) replaySpool() (int, error) {
+	replayed := 0
+	var dropped []error
+	var err error
+	for {
+		var msg *sarama.ProducerMessage
+		if msg, err = p.spool.next(); err != nil || msg == nil {
+			break
+		}
+
+		start := time.Now()
+		_, err = p.cb.Execute(func() (interface{}, error) {
+			_, _, err := p.producer.SendMessage(msg)
+			return nil, err
+		})
+		if err != nil {
+			if !errors.Is(err, gobreaker.ErrOpenState) && !errors.Is(err, gobreaker.ErrTooManyRequests) {
+				p.metrics.recordPublish(context.Background(), msg.Topic, start, 0, 1, err)
+			}
+			if !permanentPublishError(err) {
+				break
+			}
+			p.logger.Error("Dropping spooled message after permanent publish failure",
+				zap.String("topic", msg.Topic),
+				zap.Error(err),
+			)
+			dropped = append(dropped, fmt.Errorf("dropped spooled message for %s: %w", msg.Topic, err))
+			if err = p.spool.drop(); err != nil {
+				break
+			}
+			continue
+		}
+		p.metrics.recordPublish(context.Background(), msg.Topic, start, 1, 0, nil)
+
+		if err = p.spool.ack(); err != nil {
+			break
+		}
+		replayed++
+
+		select {
+		case <-p.stop:
+			return replayed, errors.Join(dropped...)
+		default:
+		}
+	}
+
+	if replayed == 0 && err == nil && len(dropped) == 0 {
+		return 0, nil
+	}
+
+	stats := p.spool.Stats()
+	if err != nil && !errors.Is(err, gobreaker.ErrOpenState) && !errors.Is(err, gobreaker.ErrTooManyRequests) {
+		p.logger.Warn("Spool replay interrupted",
+			zap.Int("replayed", replayed),
+			zap.Int("remaining", stats.Records),
+			zap.Error(err),
+		)
+	} else if replayed > 0 {
+		p.logger.Info("Replayed spooled messages",
+			zap.Int("replayed", replayed),
+			zap.Int("remaining", stats.Records),
+		)
+	}
+	err = errors.Join(append(dropped, err)...)
+	if p.spool.cfg.OnReplay != nil {
+		p.spool.cfg.OnReplay(replayed, stats, err)
+	}
+	return replayed, err
+}
+
+// SpoolStats returns a snapshot of the producer's spool; it is zero when no spool is configured
+func (p *Producer) SpoolStats() SpoolStats {
+	if p.spool == nil {
+		return SpoolStats{}
+	}
+	return p.spool.Stats()
+}
@@ -0,0 +1,259 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap/zaptest"
+)
+
+// newTestSpoolProducer returns a producer whose breaker trips on the first
+// failure and half-opens after timeout. Replay is driven by the test.
+func newTestSpoolProducer(t *testing.T, cfg SpoolConfig, timeout time.Duration) (*Producer, *mocks.SyncProducer) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	mockProducer := mocks.NewSyncProducer(t, config)
+
+	s, err := openSpool(cfg, zaptest.NewLogger(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.close() })
+
+	return &Producer{
+		producer: mockProducer,
+		cb: gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:         "kafka-producer-test",
+			Timeout:      timeout,
+			ReadyToTrip:  func(counts gobreaker.Counts) bool { return counts.ConsecutiveFailures >= 1 },
+			IsSuccessful: breakerSuccess,
+		}),
+		logger: zaptest.NewLogger(t),
+		tracer: otel.Tracer("test"),
+		spool:  s,
+	}, mockProducer
+}
+
+func expectValue(mockProducer *mocks.SyncProducer, want string) {
+	mockProducer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+		if string(val) != want {
+			return fmt.Errorf("got %s, want %s", val, want)
+		}
+		return nil
+	})
+}
+
+func testProducerMessage(value string) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic: "banking.audit",
+		Key:   sarama.StringEncoder("user-1"),
+		Value: sarama.StringEncoder(value),
+	}
+}
+
+func TestProducer_SpoolsWhileBreakerOpenAndReplaysInOrder(t *testing.T) {
+	p, mockProducer := newTestSpoolProducer(t, SpoolConfig{Dir: t.TempDir()}, 50*time.Millisecond)
+	ctx := context.Background()
+
+	mockProducer.ExpectSendMessageAndFail(errors.New("broker down"))
+	require.Error(t, p.PublishMessage(ctx, testProducerMessage("0")))
+	require.Equal(t, gobreaker.StateOpen, p.cb.State())
+
+	require.NoError(t, p.PublishMessage(ctx, testProducerMessage("1")))
+	require.NoError(t, p.PublishMessage(ctx, testProducerMessage("2")))
+	assert.Equal(t, 2, p.SpoolStats().Records)
+
+	// Replay is refused while the breaker is open
+	n, err := p.replaySpool()
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, gobreaker.ErrOpenState)
+
+	time.Sleep(60 * time.Millisecond)
+	expectValue(mockProducer, "1")
+	expectValue(mockProducer, "2")
+	n, err = p.replaySpool()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	stats := p.SpoolStats()
+	assert.Equal(t, 0, stats.Records)
+	assert.Equal(t, int64(0), stats.Bytes)
+	assert.Equal(t, uint64(2), stats.Spooled)
+	assert.Equal(t, uint64(2), stats.Replayed)
+
+	// Back to direct publishing once the spool is drained
+	expectValue(mockProducer, "3")
+	require.NoError(t, p.PublishMessage(ctx, testProducerMessage("3")))
+	assert.Equal(t, uint64(2), p.SpoolStats().Spooled)
+}
+
+func TestProducer_SpoolsBehindPendingRecords(t *testing.T) {
+	p, _ := newTestSpoolProducer(t, SpoolConfig{Dir: t.TempDir()}, time.Minute)
+	ctx := context.Background()
+
+	require.NoError(t, p.spool.append(ctx, testProducerMessage("1")))
+
+	// The breaker is closed, but the new message must not overtake the spooled one
+	require.NoError(t, p.PublishMessage(ctx, testProducerMessage("2")))
+	assert.Equal(t, 2, p.SpoolStats().Records)
+}
+
+func TestProducer_PublishBatchSpoolsBehindPendingRecords(t *testing.T) {
+	p, _ := newTestSpoolProducer(t, SpoolConfig{Dir: t.TempDir()}, time.Minute)
+	ctx := context.Background()
+
+	require.NoError(t, p.spool.append(ctx, testProducerMessage("1")))
+
+	// No send is expected: the mock producer fails the test on an unexpected message
+	result, err := p.PublishBatch(ctx, "banking.audit", []Event{MockEvent{ID: "2"}, MockEvent{ID: "3"}})
+	require.NoError(t, err)
+	assert.Empty(t, result.Failed())
+	assert.Equal(t, int64(-1), result.Results[0].Offset)
+	assert.Equal(t, 3, p.SpoolStats().Records)
+}
+
+func TestProducer_ReplayDropsPermanentlyFailingRecord(t *testing.T) {
+	var reported error
+	p, mockProducer := newTestSpoolProducer(t, SpoolConfig{
+		Dir: t.TempDir(),
+		OnReplay: func(replayed int, stats SpoolStats, err error) {
+			reported = err
+		},
+	}, time.Minute)
+	ctx := context.Background()
+
+	require.NoError(t, p.spool.append(ctx, testProducerMessage("1")))
+	require.NoError(t, p.spool.append(ctx, testProducerMessage("2")))
+
+	mockProducer.ExpectSendMessageAndFail(sarama.ErrMessageSizeTooLarge)
+	expectValue(mockProducer, "2")
+	n, err := p.replaySpool()
+	assert.Equal(t, 1, n)
+	require.ErrorIs(t, err, sarama.ErrMessageSizeTooLarge)
+	assert.ErrorIs(t, reported, sarama.ErrMessageSizeTooLarge)
+	assert.Equal(t, gobreaker.StateClosed, p.cb.State(), "a rejected message does not trip the breaker")
+
+	stats := p.SpoolStats()
+	assert.Equal(t, 0, stats.Records)
+	assert.Equal(t, uint64(1), stats.Replayed)
+	assert.Equal(t, uint64(1), stats.Dropped)
+}
+
+func TestProducer_ReplayKeepsRetryableFailure(t *testing.T) {
+	p, mockProducer := newTestSpoolProducer(t, SpoolConfig{Dir: t.TempDir()}, time.Minute)
+	require.NoError(t, p.spool.append(context.Background(), testProducerMessage("1")))
+
+	mockProducer.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+	n, err := p.replaySpool()
+	assert.Equal(t, 0, n)
+	require.ErrorIs(t, err, sarama.ErrNotLeaderForPartition)
+	assert.Equal(t, 1, p.SpoolStats().Records)
+}
+
+func TestProducer_CloseTwice(t *testing.T) {
+	p, mockProducer := newTestSpoolProducer(t, SpoolConfig{Dir: t.TempDir()}, time.Minute)
+	p.startReplay()
+	mockProducer.ExpectSendMessageAndSucceed()
+	require.NoError(t, p.PublishMessage(context.Background(), testProducerMessage("1")))
+
+	require.NoError(t, p.Close())
+	assert.NotPanics(t, func() { assert.NoError(t, p.Close()) })
+}
+
+func TestSpool_RecoversAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	logger := zaptest.NewLogger(t)
+
+	s, err := openSpool(SpoolConfig{Dir: dir, SegmentBytes: 150}, logger)
+	require.NoError(t, err)
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, s.append(ctx, testProducerMessage(fmt.Sprint(i))))
+	}
+	msg, err := s.next()
+	require.NoError(t, err)
+	assert.Equal(t, sarama.ByteEncoder("1"), msg.Value)
+	require.NoError(t, s.ack())
+	require.NoError(t, s.close())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	require.Greater(t, len(segments), 1, "small segments rotate")
+
+	// Simulate a torn write at the tail of the last segment
+	last := segments[len(segments)-1]
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 42, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = openSpool(SpoolConfig{Dir: dir, SegmentBytes: 150}, logger)
+	require.NoError(t, err)
+	defer s.close()
+	assert.Equal(t, 3, s.Stats().Records)
+
+	require.NoError(t, s.append(ctx, testProducerMessage("5")))
+	var values []string
+	for {
+		msg, err := s.next()
+		require.NoError(t, err)
+		if msg == nil {
+			break
+		}
+		values = append(values, string(msg.Value.(sarama.ByteEncoder)))
+		require.NoError(t, s.ack())
+	}
+	assert.Equal(t, []string{"2", "3", "4", "5"}, values)
+
+	segments, _ = filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	assert.Empty(t, segments, "drained segments are deleted")
+}
+
+func TestSpool_FullPolicy(t *testing.T) {
+	ctx := context.Background()
+	size := func() int64 {
+		data, err := encodeSpoolRecord(testProducerMessage("1"))
+		require.NoError(t, err)
+		return int64(spoolHeaderSize + len(data))
+	}()
+
+	t.Run("Reject", func(t *testing.T) {
+		s, err := openSpool(SpoolConfig{Dir: t.TempDir(), MaxBytes: size}, zaptest.NewLogger(t))
+		require.NoError(t, err)
+		defer s.close()
+
+		require.NoError(t, s.append(ctx, testProducerMessage("1")))
+		assert.ErrorIs(t, s.append(ctx, testProducerMessage("2")), ErrSpoolFull)
+		assert.Equal(t, uint64(1), s.Stats().Rejected)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		s, err := openSpool(SpoolConfig{Dir: t.TempDir(), MaxBytes: size, FullPolicy: SpoolFullBlock}, zaptest.NewLogger(t))
+		require.NoError(t, err)
+		defer s.close()
+
+		require.NoError(t, s.append(ctx, testProducerMessage("1")))
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, s.append(timeoutCtx, testProducerMessage("2")), context.DeadlineExceeded)
+
+		appended := make(chan error, 1)
+		go func() { appended <- s.append(ctx, testProducerMessage("2")) }()
+
+		_, err = s.next()
+		require.NoError(t, err)
+		require.NoError(t, s.ack())
+		require.NoError(t, <-appended)
+		assert.Equal(t, 1, s.Stats().Records)
+	})
+}
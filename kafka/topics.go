@@ -0,0 +1,295 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"go.uber.org/zap"
+)
+
+// Topic settings compared by TopicAdmin
+const (
+	settingPartitions        = "partitions"
+	settingReplicationFactor = "replication.factor"
+	configRetention          = "retention.ms"
+	configMinInSyncReplicas  = "min.insync.replicas"
+	configCleanupPolicy      = "cleanup.policy"
+)
+
+// AdminConfig holds the connection settings of a TopicAdmin
+type AdminConfig struct {
+	Brokers  []string
+	ClientID string
+	// Security configures TLS and SASL; the zero value connects in plaintext
+	Security SecurityConfig
+}
+
+// TopicDrift is a setting of an existing topic that differs from its spec
+type TopicDrift struct {
+	Topic   string
+	Setting string
+	Want    string
+	Got     string
+}
+
+func (d TopicDrift) String() string {
+	return fmt.Sprintf("%s %s: want %s, got %s", d.Topic, d.Setting, d.Want, d.Got)
+}
+
+// fixable reports whether the drift can be corrected in place. Partitions
+// can only be added, and replication changes need a reassignment plan.
+func (d TopicDrift) fixable() bool {
+	switch d.Setting {
+	case settingReplicationFactor:
+		return false
+	case settingPartitions:
+		want, _ := strconv.Atoi(d.Want)
+		got, _ := strconv.Atoi(d.Got)
+		return want > got
+	default:
+		return true
+	}
+}
+
+// TopicReport lists the topics of a TopicConfig that are missing or have drifted
+type TopicReport struct {
+	Missing []string
+	Drift   []TopicDrift
+}
+
+// InSync reports whether every topic exists and matches its spec
+func (r *TopicReport) InSync() bool {
+	return len(r.Missing) == 0 && len(r.Drift) == 0
+}
+
+// TopicAdmin provisions topics from an events.TopicConfig
+type TopicAdmin struct {
+	admin  sarama.ClusterAdmin
+	logger *zap.Logger
+}
+
+// NewTopicAdmin connects a TopicAdmin to the cluster
+func NewTopicAdmin(cfg AdminConfig, logger *zap.Logger) (*TopicAdmin, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("at least one broker is required")
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = cfg.ClientID
+	// IncrementalAlterConfigs needs Kafka 2.3
+	config.Version = sarama.V2_3_0_0
+	if err := cfg.Security.apply(config); err != nil {
+		return nil, fmt.Errorf("invalid security config: %w", err)
+	}
+
+	admin, err := sarama.NewClusterAdmin(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka admin: %w", err)
+	}
+	return NewTopicAdminFromClient(admin, logger), nil
+}
+
+// NewTopicAdminFromClient creates a TopicAdmin on an existing cluster admin.
+// Close closes admin.
+func NewTopicAdminFromClient(admin sarama.ClusterAdmin, logger *zap.Logger) *TopicAdmin {
+	return &TopicAdmin{admin: admin, logger: logger}
+}
+
+// Close closes the cluster admin
+func (a *TopicAdmin) Close() error {
+	return a.admin.Close()
+}
+
+// Plan compares the cluster with topics and reports missing topics and drift
+// without changing anything
+func (a *TopicAdmin) Plan(ctx context.Context, topics events.TopicConfig) (*TopicReport, error) {
+	if err := topics.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid topic config: %w", err)
+	}
+
+	existing, err := a.admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	report := &TopicReport{}
+	for _, topic := range topics.Names() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		detail, ok := existing[topic]
+		if !ok {
+			report.Missing = append(report.Missing, topic)
+			continue
+		}
+		drift, err := a.diff(topic, detail, topics.Spec(topic))
+		if err != nil {
+			return nil, err
+		}
+		report.Drift = append(report.Drift, drift...)
+	}
+	return report, nil
+}
+
+// Apply creates missing topics and corrects drift. Drift that cannot be
+// corrected in place, such as fewer partitions or a different replication
+// factor, is left unchanged and returned as an error along with the report
+// of everything found.
+func (a *TopicAdmin) Apply(ctx context.Context, topics events.TopicConfig) (*TopicReport, error) {
+	report, err := a.Plan(ctx, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, topic := range report.Missing {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		spec := topics.Spec(topic)
+		detail := &sarama.TopicDetail{
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+			ConfigEntries:     make(map[string]*string),
+		}
+		for name, value := range specConfig(spec) {
+			detail.ConfigEntries[name] = &value
+		}
+		if err := a.admin.CreateTopic(topic, detail, false); err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+			return report, fmt.Errorf("failed to create topic %s: %w", topic, err)
+		}
+		a.logger.Info("Created topic",
+			zap.String("topic", topic),
+			zap.Int32("partitions", spec.Partitions),
+			zap.Int16("replication_factor", spec.ReplicationFactor),
+		)
+	}
+
+	var unfixable []string
+	configs := make(map[string]map[string]sarama.IncrementalAlterConfigsEntry)
+	configDrift := make(map[string][]TopicDrift)
+	for _, d := range report.Drift {
+		if !d.fixable() {
+			unfixable = append(unfixable, d.String())
+			continue
+		}
+		if d.Setting == settingPartitions {
+			count, _ := strconv.ParseInt(d.Want, 10, 32)
+			if err := a.admin.CreatePartitions(d.Topic, int32(count), nil, false); err != nil {
+				a.logDriftFailure(d, err)
+				return report, fmt.Errorf("failed to add partitions to %s: %w", d.Topic, err)
+			}
+			a.logDriftCorrected(d)
+			continue
+		}
+		if configs[d.Topic] == nil {
+			configs[d.Topic] = make(map[string]sarama.IncrementalAlterConfigsEntry)
+		}
+		want := d.Want
+		configs[d.Topic][d.Setting] = sarama.IncrementalAlterConfigsEntry{
+			Operation: sarama.IncrementalAlterConfigsOperationSet,
+			Value:     &want,
+		}
+		configDrift[d.Topic] = append(configDrift[d.Topic], d)
+	}
+	for topic, entries := range configs {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if err := a.admin.IncrementalAlterConfig(sarama.TopicResource, topic, entries, false); err != nil {
+			for _, d := range configDrift[topic] {
+				a.logDriftFailure(d, err)
+			}
+			return report, fmt.Errorf("failed to update config of topic %s: %w", topic, err)
+		}
+		for _, d := range configDrift[topic] {
+			a.logDriftCorrected(d)
+		}
+	}
+
+	if len(unfixable) > 0 {
+		return report, fmt.Errorf("topic drift cannot be corrected in place: %s", strings.Join(unfixable, "; "))
+	}
+	return report, nil
+}
+
+// logDriftCorrected logs drift once the cluster has accepted the correction
+func (a *TopicAdmin) logDriftCorrected(d TopicDrift) {
+	a.logger.Warn("Corrected topic drift",
+		zap.String("topic", d.Topic),
+		zap.String("setting", d.Setting),
+		zap.String("want", d.Want),
+		zap.String("got", d.Got),
+	)
+}
+
+// logDriftFailure logs drift whose correction the cluster rejected
+func (a *TopicAdmin) logDriftFailure(d TopicDrift, err error) {
+	a.logger.Error("Failed to correct topic drift",
+		zap.String("topic", d.Topic),
+		zap.String("setting", d.Setting),
+		zap.String("want", d.Want),
+		zap.String("got", d.Got),
+		zap.Error(err),
+	)
+}
+
+// diff compares an existing topic with its spec
+func (a *TopicAdmin) diff(topic string, detail sarama.TopicDetail, spec events.TopicSpec) ([]TopicDrift, error) {
+	var drift []TopicDrift
+	if detail.NumPartitions != spec.Partitions {
+		drift = append(drift, TopicDrift{
+			Topic:   topic,
+			Setting: settingPartitions,
+			Want:    strconv.Itoa(int(spec.Partitions)),
+			Got:     strconv.Itoa(int(detail.NumPartitions)),
+		})
+	}
+	if detail.ReplicationFactor != spec.ReplicationFactor {
+		drift = append(drift, TopicDrift{
+			Topic:   topic,
+			Setting: settingReplicationFactor,
+			Want:    strconv.Itoa(int(spec.ReplicationFactor)),
+			Got:     strconv.Itoa(int(detail.ReplicationFactor)),
+		})
+	}
+
+	// Effective values, including broker defaults
+	entries, err := a.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: topic})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe config of topic %s: %w", topic, err)
+	}
+	actual := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		actual[entry.Name] = entry.Value
+	}
+
+	want := specConfig(spec)
+	for _, name := range []string{configRetention, configMinInSyncReplicas, configCleanupPolicy} {
+		if got := actual[name]; got != want[name] {
+			drift = append(drift, TopicDrift{Topic: topic, Setting: name, Want: want[name], Got: got})
+		}
+	}
+	return drift, nil
+}
+
+// specConfig returns the topic configs that implement spec
+func specConfig(spec events.TopicSpec) map[string]string {
+	retention := "-1"
+	if spec.Retention > 0 {
+		retention = strconv.FormatInt(spec.Retention.Milliseconds(), 10)
+	}
+	cleanup := "delete"
+	if spec.Compacted {
+		cleanup = "compact"
+	}
+	return map[string]string{
+		configRetention:         retention,
+		configMinInSyncReplicas: strconv.Itoa(spec.MinInSyncReplicas),
+		configCleanupPolicy:     cleanup,
+	}
+}
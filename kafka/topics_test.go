@@ -0,0 +1,193 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeAdmin is a sarama.ClusterAdmin holding topics in memory; unused methods panic
+type fakeAdmin struct {
+	sarama.ClusterAdmin
+	topics  map[string]sarama.TopicDetail
+	configs map[string]map[string]string
+	altered map[string]map[string]string
+	// alterErr fails IncrementalAlterConfig when set
+	alterErr error
+}
+
+func newFakeAdmin() *fakeAdmin {
+	return &fakeAdmin{
+		topics:  make(map[string]sarama.TopicDetail),
+		configs: make(map[string]map[string]string),
+		altered: make(map[string]map[string]string),
+	}
+}
+
+func (a *fakeAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	return a.topics, nil
+}
+
+func (a *fakeAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, _ bool) error {
+	if _, ok := a.topics[topic]; ok {
+		return sarama.ErrTopicAlreadyExists
+	}
+	a.topics[topic] = *detail
+	a.configs[topic] = make(map[string]string)
+	for name, value := range detail.ConfigEntries {
+		a.configs[topic][name] = *value
+	}
+	return nil
+}
+
+func (a *fakeAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	var entries []sarama.ConfigEntry
+	for name, value := range a.configs[resource.Name] {
+		entries = append(entries, sarama.ConfigEntry{Name: name, Value: value})
+	}
+	return entries, nil
+}
+
+func (a *fakeAdmin) CreatePartitions(topic string, count int32, _ [][]int32, _ bool) error {
+	detail := a.topics[topic]
+	detail.NumPartitions = count
+	a.topics[topic] = detail
+	return nil
+}
+
+func (a *fakeAdmin) IncrementalAlterConfig(_ sarama.ConfigResourceType, name string, entries map[string]sarama.IncrementalAlterConfigsEntry, _ bool) error {
+	if a.alterErr != nil {
+		return a.alterErr
+	}
+	if a.altered[name] == nil {
+		a.altered[name] = make(map[string]string)
+	}
+	for key, entry := range entries {
+		a.configs[name][key] = *entry.Value
+		a.altered[name][key] = *entry.Value
+	}
+	return nil
+}
+
+// provision creates every topic of cfg as specified
+func (a *fakeAdmin) provision(t *testing.T, cfg events.TopicConfig) {
+	_, err := NewTopicAdminFromClient(a, zaptest.NewLogger(t)).Apply(context.Background(), cfg)
+	require.NoError(t, err)
+}
+
+func TestTopicAdmin_ApplyCreatesMissingTopics(t *testing.T) {
+	admin := newFakeAdmin()
+	cfg := events.DefaultTopicConfig()
+	topics := NewTopicAdminFromClient(admin, zaptest.NewLogger(t))
+	ctx := context.Background()
+
+	report, err := topics.Plan(ctx, cfg)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, cfg.Names(), report.Missing)
+	assert.Empty(t, admin.topics, "plan does not change the cluster")
+
+	_, err = topics.Apply(ctx, cfg)
+	require.NoError(t, err)
+	require.Len(t, admin.topics, len(cfg.Names()))
+
+	audit := admin.topics[cfg.AuditLog]
+	assert.Equal(t, int32(6), audit.NumPartitions)
+	assert.Equal(t, int16(3), audit.ReplicationFactor)
+	assert.Equal(t, strconv.FormatInt(events.RegulatoryRetention.Milliseconds(), 10), admin.configs[cfg.AuditLog]["retention.ms"])
+	assert.Equal(t, "604800000", admin.configs[cfg.TransactionInitiated]["retention.ms"])
+	assert.Equal(t, "2", admin.configs[cfg.TransactionInitiated]["min.insync.replicas"])
+	assert.Equal(t, "delete", admin.configs[cfg.TransactionInitiated]["cleanup.policy"])
+
+	report, err = topics.Plan(ctx, cfg)
+	require.NoError(t, err)
+	assert.True(t, report.InSync())
+}
+
+func TestTopicAdmin_DriftIsReportedAndCorrected(t *testing.T) {
+	admin := newFakeAdmin()
+	cfg := events.DefaultTopicConfig()
+	admin.provision(t, cfg)
+	topics := NewTopicAdminFromClient(admin, zaptest.NewLogger(t))
+	ctx := context.Background()
+
+	// Someone shortened audit retention and dropped a partition count by hand
+	admin.configs[cfg.AuditLog]["retention.ms"] = "86400000"
+	detail := admin.topics[cfg.FraudAnalysis]
+	detail.NumPartitions = 3
+	admin.topics[cfg.FraudAnalysis] = detail
+
+	report, err := topics.Plan(ctx, cfg)
+	require.NoError(t, err)
+	assert.Empty(t, report.Missing)
+	assert.ElementsMatch(t, []TopicDrift{
+		{Topic: cfg.AuditLog, Setting: "retention.ms", Want: strconv.FormatInt(events.RegulatoryRetention.Milliseconds(), 10), Got: "86400000"},
+		{Topic: cfg.FraudAnalysis, Setting: "partitions", Want: "6", Got: "3"},
+	}, report.Drift)
+	assert.Empty(t, admin.altered, "plan does not change the cluster")
+
+	_, err = topics.Apply(ctx, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"retention.ms": strconv.FormatInt(events.RegulatoryRetention.Milliseconds(), 10)}, admin.altered[cfg.AuditLog])
+	assert.Equal(t, int32(6), admin.topics[cfg.FraudAnalysis].NumPartitions)
+
+	report, err = topics.Plan(ctx, cfg)
+	require.NoError(t, err)
+	assert.True(t, report.InSync())
+}
+
+func TestTopicAdmin_LogsDriftOnlyOnceCorrected(t *testing.T) {
+	admin := newFakeAdmin()
+	cfg := events.DefaultTopicConfig()
+	admin.provision(t, cfg)
+	admin.configs[cfg.AuditLog]["retention.ms"] = "86400000"
+	admin.alterErr = sarama.ErrClusterAuthorizationFailed
+
+	core, logs := observer.New(zap.InfoLevel)
+	_, err := NewTopicAdminFromClient(admin, zap.New(core)).Apply(context.Background(), cfg)
+	require.ErrorIs(t, err, sarama.ErrClusterAuthorizationFailed)
+
+	assert.Zero(t, logs.FilterMessage("Corrected topic drift").Len())
+	failed := logs.FilterMessage("Failed to correct topic drift").All()
+	require.Len(t, failed, 1)
+	assert.Equal(t, cfg.AuditLog, failed[0].ContextMap()["topic"])
+	assert.Equal(t, "retention.ms", failed[0].ContextMap()["setting"])
+}
+
+func TestTopicAdmin_UnfixableDrift(t *testing.T) {
+	admin := newFakeAdmin()
+	cfg := events.DefaultTopicConfig()
+	admin.provision(t, cfg)
+	topics := NewTopicAdminFromClient(admin, zaptest.NewLogger(t))
+
+	detail := admin.topics[cfg.UserEvents]
+	detail.NumPartitions = 12
+	detail.ReplicationFactor = 2
+	admin.topics[cfg.UserEvents] = detail
+	admin.configs[cfg.UserEvents]["min.insync.replicas"] = "1"
+
+	report, err := topics.Apply(context.Background(), cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), cfg.UserEvents+" partitions: want 6, got 12")
+	assert.Contains(t, err.Error(), cfg.UserEvents+" replication.factor: want 3, got 2")
+	assert.Len(t, report.Drift, 3)
+	assert.Equal(t, "2", admin.configs[cfg.UserEvents]["min.insync.replicas"], "fixable drift is still corrected")
+	assert.Equal(t, int32(12), admin.topics[cfg.UserEvents].NumPartitions)
+}
+
+func TestTopicAdmin_RejectsInvalidConfig(t *testing.T) {
+	admin := newFakeAdmin()
+	cfg := events.DefaultTopicConfig()
+	delete(cfg.Specs, cfg.AuditLog)
+
+	_, err := NewTopicAdminFromClient(admin, zaptest.NewLogger(t)).Apply(context.Background(), cfg)
+	assert.ErrorContains(t, err, "must retain records")
+	assert.Empty(t, admin.topics)
+}
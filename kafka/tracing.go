@@ -0,0 +1,85 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// w3cPropagator is used when the application has not registered a global propagator
+var w3cPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// propagator returns the global text map propagator, falling back to
+// W3C trace context and baggage so traces are never broken at a Kafka hop.
+func propagator() propagation.TextMapPropagator {
+	p := otel.GetTextMapPropagator()
+	if len(p.Fields()) == 0 {
+		return w3cPropagator
+	}
+	return p
+}
+
+// producerCarrier adapts producer message headers to a propagation.TextMapCarrier
+type producerCarrier struct {
+	msg *sarama.ProducerMessage
+}
+
+var _ propagation.TextMapCarrier = producerCarrier{}
+
+// Get returns the value of the header with the given key
+func (c producerCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces the header with the given key, so republished messages carry the new context
+func (c producerCarrier) Set(key, value string) {
+	c.msg.Headers = setHeader(c.msg.Headers, key, value)
+}
+
+// Keys returns all header keys
+func (c producerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, string(h.Key))
+	}
+	return keys
+}
+
+// consumerCarrier adapts consumer message headers to a propagation.TextMapCarrier
+type consumerCarrier struct {
+	msg *sarama.ConsumerMessage
+}
+
+var _ propagation.TextMapCarrier = consumerCarrier{}
+
+// Get returns the value of the header with the given key
+func (c consumerCarrier) Get(key string) string {
+	return headerValue(c.msg, key)
+}
+
+// Set replaces the header with the given key
+func (c consumerCarrier) Set(key, value string) {
+	for _, h := range c.msg.Headers {
+		if h != nil && string(h.Key) == key {
+			h.Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, &sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+// Keys returns all header keys
+func (c consumerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,73 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func remoteSpanContext(t *testing.T) trace.SpanContext {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
+
+func TestProducer_Publish_InjectsTraceContext(t *testing.T) {
+	p, mockProducer := newTestProducer(t)
+	sc := remoteSpanContext(t)
+
+	member, err := baggage.NewMember("tenant", "acme")
+	require.NoError(t, err)
+	bag, err := baggage.New(member)
+	require.NoError(t, err)
+
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	ctx = baggage.ContextWithBaggage(ctx, bag)
+
+	var published *sarama.ProducerMessage
+	mockProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		published = msg
+		return nil
+	})
+
+	require.NoError(t, p.Publish(ctx, "banking.transactions.initiated", MockEvent{ID: "1"}))
+
+	headers := headerMap(published.Headers)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", headers["traceparent"])
+	assert.Equal(t, "tenant=acme", headers["baggage"])
+	assert.Equal(t, sc.TraceID().String(), headers["trace-id"])
+}
+
+func TestConsumer_ExtractsTraceContext(t *testing.T) {
+	var handlerCtx context.Context
+	c := newTestConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		handlerCtx = ctx
+		return nil
+	}, nil)
+	session := newFakeSession(context.Background())
+
+	msg := testMessage(1)
+	msg.Headers = append(msg.Headers,
+		&sarama.RecordHeader{Key: []byte("traceparent"), Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+		&sarama.RecordHeader{Key: []byte("baggage"), Value: []byte("tenant=acme")},
+	)
+
+	require.NoError(t, c.ConsumeClaim(session, newFakeClaim(msg.Topic, msg.Partition, msg)))
+	require.NotNil(t, handlerCtx)
+
+	sc := trace.SpanContextFromContext(handlerCtx)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.Equal(t, "acme", baggage.FromContext(handlerCtx).Member("tenant").Value())
+}
@@ -0,0 +1,230 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// ErrTransactionalProducerFatal is returned once the transactional producer
+// can no longer commit or abort, e.g. after it was fenced. The consumer stops
+// and reports the error through Health; it must be recreated to continue.
+var ErrTransactionalProducerFatal = errors.New("transactional producer is in a fatal state")
+
+// Output is an event emitted by a TransformHandler
+type Output struct {
+	Topic string
+	Event Event
+}
+
+// TransformHandler processes a message and returns the events to emit in response
+type TransformHandler func(ctx context.Context, msg *sarama.ConsumerMessage) ([]Output, error)
+
+// transactor writes a handler's outputs and the input offset in one Kafka transaction
+type transactor struct {
+	mu       sync.Mutex // sarama allows one open transaction per producer
+	producer sarama.SyncProducer
+	handler  TransformHandler
+	logger   *zap.Logger
+}
+
+// NewTransactionalConsumer creates a consume-transform-produce consumer. For
+// each message the events returned by handler and the message's offset are
+// committed in a single Kafka transaction, so a crash never leaves outputs
+// published without the offset committed or vice versa. producerCfg must set
+// TransactionalID, which has to be stable across restarts of the same instance
+// and unique among instances so that zombie producers are fenced.
+//
+// Retry and dead-letter policies apply to handler errors as usual; the
+// offsets of retried and dead-lettered messages are committed in their own
+// transaction after the copy is published, which is at-least-once.
+func NewTransactionalConsumer(cfg ConsumerConfig, producerCfg ProducerConfig, handler TransformHandler, logger *zap.Logger) (*Consumer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if producerCfg.TransactionalID == "" {
+		return nil, errors.New("transactional consumer requires a transactional ID")
+	}
+	if err := producerCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+	if cfg.Concurrency > 1 {
+		return nil, errors.New("transactional consumer does not support concurrency")
+	}
+
+	producerConfig, err := newSaramaProducerConfig(producerCfg)
+	if err != nil {
+		return nil, err
+	}
+	config, err := newSaramaConsumerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := newConsumerMetrics(cfg.MeterProvider, cfg.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewSyncProducer(producerCfg.Brokers, producerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka transactional producer: %w", err)
+	}
+
+	// Outputs of aborted transactions must not be consumed downstream, and
+	// offsets are committed through the producer rather than the session
+	config.Consumer.IsolationLevel = sarama.ReadCommitted
+	config.Consumer.Offsets.AutoCommit.Enable = false
+
+	client, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID, config)
+	if err != nil {
+		_ = producer.Close()
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	c := newConsumer(cfg, client, nil, metrics, logger)
+	c.txn = &transactor{producer: producer, handler: handler, logger: logger}
+	return c, nil
+}
+
+// consumeTransactional processes messages sequentially, committing each
+// message's outputs and offset in one transaction
+func (c *Consumer) consumeTransactional(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim, lag *claimLag) error {
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+
+			var (
+				outputs []Output
+				outCtx  context.Context
+			)
+			done, err := c.processWith(session.Context(), message, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				out, err := c.txn.handler(ctx, msg)
+				if err != nil {
+					return err
+				}
+				outputs, outCtx = out, ctx
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if !done {
+				continue
+			}
+
+			if outCtx == nil {
+				// Retried or dead-lettered: only the offset is committed
+				outCtx, outputs = session.Context(), nil
+			}
+			if err := c.txn.commit(outCtx, c.groupID, message, outputs); err != nil {
+				// Ending the session redelivers the message from the last committed offset
+				err = fmt.Errorf("failed to commit transaction for message from %s/%d@%d: %w",
+					message.Topic, message.Partition, message.Offset, err)
+				if errors.Is(err, ErrTransactionalProducerFatal) {
+					c.fail(err)
+				}
+				return err
+			}
+			lag.advance(message.Offset + 1)
+
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// commit publishes outputs and adds msg's offset to the group in one transaction
+func (t *transactor) commit(ctx context.Context, groupID string, msg *sarama.ConsumerMessage, outputs []Output) error {
+	msgs, err := outputMessages(ctx, outputs)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.producer.BeginTxn(); err != nil {
+		if t.fatal() {
+			return fmt.Errorf("%w: failed to begin transaction: %w", ErrTransactionalProducerFatal, err)
+		}
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := t.send(msgs, groupID, msg); err != nil {
+		return t.abort(err)
+	}
+
+	if err := t.producer.CommitTxn(); err != nil {
+		return t.abort(fmt.Errorf("failed to commit transaction: %w", err))
+	}
+	return nil
+}
+
+func (t *transactor) send(msgs []*sarama.ProducerMessage, groupID string, msg *sarama.ConsumerMessage) error {
+	if len(msgs) > 0 {
+		if err := t.producer.SendMessages(msgs); err != nil {
+			return fmt.Errorf("failed to publish transaction outputs: %w", err)
+		}
+	}
+	if err := t.producer.AddMessageToTxn(msg, groupID, nil); err != nil {
+		return fmt.Errorf("failed to add offset to transaction: %w", err)
+	}
+	return nil
+}
+
+// abort rolls back the open transaction after err and returns err. If the
+// producer is in a fatal state nothing can be rolled back and err is wrapped
+// in ErrTransactionalProducerFatal.
+func (t *transactor) abort(err error) error {
+	if t.fatal() {
+		return fmt.Errorf("%w: %w", ErrTransactionalProducerFatal, err)
+	}
+	if aerr := t.producer.AbortTxn(); aerr != nil {
+		t.logger.Error("Failed to abort transaction", zap.Error(aerr))
+		if t.fatal() {
+			return fmt.Errorf("%w: %w", ErrTransactionalProducerFatal, err)
+		}
+	}
+	return err
+}
+
+// fatal reports whether the producer can no longer run transactions
+func (t *transactor) fatal() bool {
+	return t.producer.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0
+}
+
+// outputMessages serializes outputs, propagating the trace context of ctx
+func outputMessages(ctx context.Context, outputs []Output) ([]*sarama.ProducerMessage, error) {
+	traceID := trace.SpanContextFromContext(ctx).TraceID().String()
+
+	msgs := make([]*sarama.ProducerMessage, 0, len(outputs))
+	for _, out := range outputs {
+		payload, err := json.Marshal(out.Event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event for %s: %w", out.Topic, err)
+		}
+
+		m := &sarama.ProducerMessage{
+			Topic: out.Topic,
+			Key:   sarama.StringEncoder(out.Event.Key()),
+			Value: sarama.ByteEncoder(payload),
+			Headers: []sarama.RecordHeader{
+				{Key: []byte("content-type"), Value: []byte("application/json")},
+			},
+		}
+		propagator().Inject(ctx, producerCarrier{m})
+		m.Headers = setHeader(m.Headers, "trace-id", traceID)
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
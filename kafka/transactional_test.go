@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// txnProducer records the transaction calls the sarama mock treats as no-ops
+type txnProducer struct {
+	*mocks.SyncProducer
+	calls     []string
+	offsets   []int64
+	commitErr error
+	// status is reported by TxnStatus once CommitTxn fails; zero means ready
+	status sarama.ProducerTxnStatusFlag
+	failed bool
+}
+
+func (p *txnProducer) BeginTxn() error {
+	p.calls = append(p.calls, "begin")
+	return nil
+}
+
+func (p *txnProducer) CommitTxn() error {
+	p.calls = append(p.calls, "commit")
+	p.failed = p.commitErr != nil
+	return p.commitErr
+}
+
+func (p *txnProducer) AbortTxn() error {
+	p.calls = append(p.calls, "abort")
+	return nil
+}
+
+func (p *txnProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupID string, metadata *string) error {
+	p.calls = append(p.calls, "offset")
+	p.offsets = append(p.offsets, msg.Offset)
+	return nil
+}
+
+func (p *txnProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	p.calls = append(p.calls, "send")
+	return p.SyncProducer.SendMessages(msgs)
+}
+
+func (p *txnProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	if p.failed && p.status != 0 {
+		return p.status
+	}
+	return sarama.ProducerTxnFlagReady
+}
+
+func newTestTransactionalConsumer(t *testing.T, handler TransformHandler, dlq *DeadLetterPolicy) (*Consumer, *txnProducer) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	producer := &txnProducer{SyncProducer: mocks.NewSyncProducer(t, config)}
+
+	c := newTestConsumer(t, nil, dlq)
+	c.txn = &transactor{producer: producer, handler: handler, logger: zaptest.NewLogger(t)}
+	return c, producer
+}
+
+func TestTransactionalConsumer_CommitsOutputsWithOffset(t *testing.T) {
+	c, producer := newTestTransactionalConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) ([]Output, error) {
+		return []Output{
+			{Topic: "banking.fraud.analysis", Event: MockEvent{ID: "1", Data: "ok"}},
+			{Topic: "banking.audit", Event: MockEvent{ID: "1", Data: "ok"}},
+		}, nil
+	}, nil)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+
+	session := newFakeSession(context.Background())
+
+	err := c.ConsumeClaim(session, newFakeClaim("banking.transactions.initiated", 2, testMessage(7)))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"begin", "send", "offset", "commit"}, producer.calls)
+	assert.Equal(t, []int64{7}, producer.offsets)
+	assert.Empty(t, session.markedOffsets(), "offsets are committed through the transaction")
+}
+
+func TestTransactionalConsumer_DeadLetterCommitsOffsetOnly(t *testing.T) {
+	dlqProducer, dlqMock := newTestProducer(t)
+	dlqMock.ExpectSendMessageAndSucceed()
+
+	c, producer := newTestTransactionalConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) ([]Output, error) {
+		return nil, NonRetryable(errors.New("bad payload"))
+	}, &DeadLetterPolicy{Producer: dlqProducer, MaxAttempts: 3})
+
+	err := c.ConsumeClaim(newFakeSession(context.Background()), newFakeClaim("banking.transactions.initiated", 2, testMessage(3)))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"begin", "offset", "commit"}, producer.calls)
+	assert.Equal(t, []int64{3}, producer.offsets)
+}
+
+func TestTransactionalConsumer_CommitFailureEndsSession(t *testing.T) {
+	c, producer := newTestTransactionalConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) ([]Output, error) {
+		return []Output{{Topic: "banking.fraud.analysis", Event: MockEvent{ID: "1"}}}, nil
+	}, nil)
+	producer.ExpectSendMessageAndSucceed()
+	producer.commitErr = errors.New("coordinator unavailable")
+
+	err := c.ConsumeClaim(newFakeSession(context.Background()), newFakeClaim("banking.transactions.initiated", 2, testMessage(1), testMessage(2)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "coordinator unavailable")
+	assert.Equal(t, []string{"begin", "send", "offset", "commit", "abort"}, producer.calls)
+}
+
+func TestTransactionalConsumer_FatalProducerStopsConsumer(t *testing.T) {
+	c, producer := newTestTransactionalConsumer(t, func(ctx context.Context, msg *sarama.ConsumerMessage) ([]Output, error) {
+		return nil, nil
+	}, nil)
+	producer.commitErr = sarama.ErrProducerFenced
+	producer.status = sarama.ProducerTxnFlagFatalError
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.cancel = cancel
+
+	err := c.ConsumeClaim(newFakeSession(ctx), newFakeClaim("banking.transactions.initiated", 2, testMessage(7), testMessage(8)))
+	require.ErrorIs(t, err, ErrTransactionalProducerFatal)
+	assert.ErrorIs(t, err, sarama.ErrProducerFenced)
+	assert.Equal(t, []string{"begin", "offset", "commit"}, producer.calls, "a fatal producer cannot abort")
+	assert.Error(t, ctx.Err(), "the consumer is stopped")
+
+	health := c.Health()
+	assert.Equal(t, ConsumerStateFailed, health.State)
+	assert.False(t, health.Ready())
+	assert.ErrorIs(t, health.LastError, ErrTransactionalProducerFatal)
+
+	c.Cleanup(nil)
+	assert.Equal(t, ConsumerStateFailed, c.Health().State, "the failure is sticky")
+}
+
+func TestNewTransactionalConsumer_RequiresTransactionalID(t *testing.T) {
+	_, err := NewTransactionalConsumer(
+		ConsumerConfig{Brokers: []string{"localhost:9092"}, GroupID: "fraud"},
+		DefaultProducerConfig([]string{"localhost:9092"}, "fraud"),
+		func(ctx context.Context, msg *sarama.ConsumerMessage) ([]Output, error) { return nil, nil },
+		zaptest.NewLogger(t),
+	)
+	require.Error(t, err)
+}
@@ -0,0 +1,85 @@
+// Package outbox provides a transactional outbox so events are published
+// if and only if the business transaction that produced them commits.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/banking/shared/events"
+	"github.com/banking/shared/kafka"
+)
+
+// Record is a serialized event waiting in the outbox
+type Record struct {
+	ID        int64
+	EventID   string
+	EventType events.EventType
+	Key       string
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+// Store persists outbox records
+type Store interface {
+	// Insert adds a record inside the caller's transaction
+	Insert(ctx context.Context, tx *sql.Tx, rec Record) error
+	// ProcessPending locks up to limit pending records in insertion order,
+	// passes them to fn and marks the records in fn's Outcome as sent or
+	// failed. Only one call processes a store at a time; while another is
+	// active it returns 0 without calling fn. It returns the number marked sent.
+	ProcessPending(ctx context.Context, limit int, fn func(ctx context.Context, recs []Record) Outcome) (int, error)
+}
+
+// Outcome is the result of relaying a batch of records
+type Outcome struct {
+	// Sent are the IDs of the records that were published
+	Sent []int64
+	// Failed are the records that can never be published, by ID, with the
+	// reason. They are set aside and no longer pending.
+	Failed map[int64]error
+}
+
+// NewRecord serializes an event into an outbox record
+func NewRecord(event kafka.Event) (Record, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	var envelope struct {
+		EventID   string           `json:"event_id"`
+		EventType events.EventType `json:"event_type"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return Record{}, fmt.Errorf("failed to read event envelope: %w", err)
+	}
+	if envelope.EventType == "" {
+		return Record{}, errors.New("event has no event_type")
+	}
+
+	return Record{
+		EventID:   envelope.EventID,
+		EventType: envelope.EventType,
+		Key:       event.Key(),
+		Payload:   payload,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Enqueue serializes an event and inserts it into the outbox inside tx, so it
+// is relayed to Kafka only if tx commits
+func Enqueue(ctx context.Context, store Store, tx *sql.Tx, event kafka.Event) error {
+	rec, err := NewRecord(event)
+	if err != nil {
+		return err
+	}
+	if err := store.Insert(ctx, tx, rec); err != nil {
+		return fmt.Errorf("failed to insert outbox record: %w", err)
+	}
+	return nil
+}
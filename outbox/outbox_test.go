@@ -0,0 +1,282 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"github.com/banking/shared/kafka"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakePublisher records published messages and fails on demand
+type fakePublisher struct {
+	published []*sarama.ProducerMessage
+	failAt    int
+}
+
+func (p *fakePublisher) PublishMessage(_ context.Context, msg *sarama.ProducerMessage) error {
+	if p.failAt > 0 && len(p.published)+1 == p.failAt {
+		p.failAt = 0
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, msg)
+	return nil
+}
+
+func newEvent() *events.TransactionInitiatedEvent {
+	return &events.TransactionInitiatedEvent{
+		BaseEvent: events.NewBaseEvent(events.EventTypeTransactionInitiated, "transfer-service"),
+		UserID:    uuid.New(),
+		Currency:  "USD",
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	evt := newEvent()
+	rec, err := NewRecord(evt)
+	require.NoError(t, err)
+
+	assert.Equal(t, evt.EventID.String(), rec.EventID)
+	assert.Equal(t, events.EventTypeTransactionInitiated, rec.EventType)
+	assert.Equal(t, evt.UserID.String(), rec.Key)
+	assert.Contains(t, string(rec.Payload), `"currency":"USD"`)
+}
+
+func TestRelay_PublishesInOrder(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	publisher := &fakePublisher{failAt: 2}
+	relay := NewRelay(store, publisher, DefaultRelayConfig(), zaptest.NewLogger(t))
+
+	first, second := newEvent(), newEvent()
+	require.NoError(t, Enqueue(ctx, store, nil, first))
+	require.NoError(t, Enqueue(ctx, store, nil, second))
+
+	n, err := relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "batch must stop at the first failure")
+	require.Len(t, store.Pending(), 1)
+	assert.Equal(t, second.EventID.String(), store.Pending()[0].EventID)
+
+	n, err = relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Empty(t, store.Pending())
+
+	require.Len(t, publisher.published, 2)
+	assert.Equal(t, "banking.transactions.initiated", publisher.published[0].Topic)
+	key, _ := publisher.published[1].Key.Encode()
+	assert.Equal(t, second.UserID.String(), string(key))
+}
+
+func TestRelay_RunStopsOnCancel(t *testing.T) {
+	store := NewMemoryStore()
+	publisher := &fakePublisher{}
+	cfg := DefaultRelayConfig()
+	cfg.PollInterval = 5 * time.Millisecond
+	relay := NewRelay(store, publisher, cfg, zaptest.NewLogger(t))
+
+	require.NoError(t, Enqueue(context.Background(), store, nil, newEvent()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.NoError(t, relay.Run(ctx))
+	assert.Len(t, publisher.published, 1)
+}
+
+func TestSQLStore_ProcessPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewSQLStore(db, "outbox")
+	require.NoError(t, err)
+
+	created := time.Now().UTC()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock\(hashtext\(\$1\)\)`).
+		WithArgs("outbox").
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(true))
+	mock.ExpectQuery(`SELECT id, event_id, event_type, message_key, payload, created_at FROM outbox\s+WHERE sent_at IS NULL AND failed_at IS NULL ORDER BY id LIMIT \$1 FOR UPDATE$`).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "event_type", "message_key", "payload", "created_at"}).
+			AddRow(int64(1), "e-1", "TransactionInitiated", "user-1", []byte(`{}`), created).
+			AddRow(int64(2), "e-2", "TransactionCompleted", "tx-2", []byte(`{}`), created))
+	mock.ExpectExec(`UPDATE outbox SET sent_at = \$1 WHERE id = \$2`).
+		WithArgs(sqlmock.AnyArg(), int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE outbox SET failed_at = \$1, last_error = \$2 WHERE id = \$3`).
+		WithArgs(sqlmock.AnyArg(), events.ErrUnrouted.Error(), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := store.ProcessPending(context.Background(), 10, func(ctx context.Context, recs []Record) Outcome {
+		require.Len(t, recs, 2)
+		assert.Equal(t, events.EventTypeTransactionCompleted, recs[1].EventType)
+		return Outcome{Sent: []int64{recs[0].ID}, Failed: map[int64]error{recs[1].ID: events.ErrUnrouted}}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStore_ProcessPendingSkipsWhileAnotherRelayIsActive(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewSQLStore(db, "outbox")
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock`).
+		WithArgs("outbox").
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(false))
+	mock.ExpectRollback()
+
+	n, err := store.ProcessPending(context.Background(), 10, func(ctx context.Context, recs []Record) Outcome {
+		t.Fatal("fn must not run without the relay lock")
+		return Outcome{}
+	})
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMemoryStore_ProcessPendingIsExclusive(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	require.NoError(t, Enqueue(ctx, store, nil, newEvent()))
+	require.NoError(t, Enqueue(ctx, store, nil, newEvent()))
+
+	n, err := store.ProcessPending(ctx, 1, func(ctx context.Context, recs []Record) Outcome {
+		nested, err := store.ProcessPending(ctx, 10, func(context.Context, []Record) Outcome {
+			t.Fatal("a second relay must not process while the first is active")
+			return Outcome{}
+		})
+		require.NoError(t, err)
+		assert.Zero(t, nested)
+		return Outcome{Sent: []int64{recs[0].ID}}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, store.Pending(), 1)
+}
+
+func TestSQLStore_InsertUsesCallerTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewSQLStore(db, "outbox")
+	require.NoError(t, err)
+
+	evt := newEvent()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO outbox`).
+		WithArgs(evt.EventID.String(), "TransactionInitiated", evt.UserID.String(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, Enqueue(context.Background(), store, tx, evt))
+	require.NoError(t, tx.Rollback())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRelay_FansOutToRoutedTopics(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	publisher := &fakePublisher{}
+	cfg := DefaultRelayConfig()
+	cfg.Routes[events.EventTypeTransactionInitiated] = []events.TopicKey{events.TopicTransactionInitiated, events.TopicAuditLog}
+	relay := NewRelay(store, publisher, cfg, zaptest.NewLogger(t))
+
+	require.NoError(t, Enqueue(ctx, store, nil, newEvent()))
+	n, err := relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, publisher.published, 2)
+	assert.Equal(t, "banking.transactions.initiated", publisher.published[0].Topic)
+	assert.Equal(t, "banking.audit.log", publisher.published[1].Topic)
+}
+
+func TestRelay_SetsEventHeaders(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	publisher := &fakePublisher{}
+	relay := NewRelay(store, publisher, DefaultRelayConfig(), zaptest.NewLogger(t))
+
+	evt := newEvent()
+	evt.CorrelationID = "req-42"
+	require.NoError(t, Enqueue(ctx, store, nil, evt))
+	_, err := relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	require.Len(t, publisher.published, 1)
+
+	headers := make(map[string]string)
+	for _, h := range publisher.published[0].Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	assert.Equal(t, map[string]string{
+		"content-type":            "application/json",
+		kafka.HeaderEventType:     "TransactionInitiated",
+		kafka.HeaderEventID:       evt.EventID.String(),
+		kafka.HeaderEventVersion:  evt.Version,
+		kafka.HeaderCorrelationID: "req-42",
+		kafka.HeaderSource:        "transfer-service",
+	}, headers)
+}
+
+func TestRelay_SetsAsideUnroutedEvents(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	publisher := &fakePublisher{}
+	relay := NewRelay(store, publisher, DefaultRelayConfig(), zaptest.NewLogger(t))
+
+	unrouted := newEvent()
+	unrouted.EventType = "TransferScheduled"
+	routed := newEvent()
+	require.NoError(t, Enqueue(ctx, store, nil, unrouted))
+	require.NoError(t, Enqueue(ctx, store, nil, routed))
+
+	n, err := relay.RelayOnce(ctx)
+	require.ErrorIs(t, err, events.ErrUnrouted)
+	assert.Equal(t, 1, n, "the routable record behind the unrouted one is published")
+	require.Len(t, publisher.published, 1)
+	assert.Equal(t, "banking.transactions.initiated", publisher.published[0].Topic)
+
+	assert.Empty(t, store.Pending())
+	require.Len(t, store.Failed(), 1)
+	assert.Equal(t, unrouted.EventID.String(), store.Failed()[0].EventID)
+
+	n, err = relay.RelayOnce(ctx)
+	require.NoError(t, err, "failed records are not retried")
+	assert.Zero(t, n)
+}
+
+func TestMemoryStore_ProcessPendingRecoversAfterPanic(t *testing.T) {
+	store := NewMemoryStore()
+	require.NoError(t, Enqueue(context.Background(), store, nil, newEvent()))
+
+	assert.Panics(t, func() {
+		_, _ = store.ProcessPending(context.Background(), 10, func(ctx context.Context, recs []Record) Outcome {
+			panic("publisher crashed")
+		})
+	})
+
+	n, err := store.ProcessPending(context.Background(), 10, func(ctx context.Context, recs []Record) Outcome {
+		return Outcome{Sent: []int64{recs[0].ID}}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "a panic does not leave the store marked as processing")
+}
@@ -0,0 +1,163 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/banking/shared/events"
+	"github.com/banking/shared/kafka"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidRecord is returned for records whose payload is not an event
+var ErrInvalidRecord = errors.New("invalid outbox record")
+
+// Publisher sends raw messages to Kafka; *kafka.Producer implements it
+type Publisher interface {
+	PublishMessage(ctx context.Context, msg *sarama.ProducerMessage) error
+}
+
+// RelayConfig holds configuration for the outbox relay
+type RelayConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	Topics       events.TopicConfig
+	// Routes maps event types to topics; nil uses events.DefaultRoutes
+	Routes events.Routes
+}
+
+// DefaultRelayConfig returns sensible defaults for the outbox relay
+func DefaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		PollInterval: time.Second,
+		BatchSize:    100,
+		Topics:       events.DefaultTopicConfig(),
+		Routes:       events.DefaultRoutes(),
+	}
+}
+
+// Relay polls the outbox and publishes pending records to Kafka
+type Relay struct {
+	store     Store
+	publisher Publisher
+	cfg       RelayConfig
+	logger    *zap.Logger
+}
+
+// NewRelay creates a new outbox relay
+func NewRelay(store Store, publisher Publisher, cfg RelayConfig, logger *zap.Logger) *Relay {
+	if cfg.Routes == nil {
+		cfg.Routes = events.DefaultRoutes()
+	}
+	return &Relay{
+		store:     store,
+		publisher: publisher,
+		cfg:       cfg,
+		logger:    logger,
+	}
+}
+
+// Run relays pending records until ctx is done. Full batches are followed
+// immediately by the next poll; otherwise the relay waits PollInterval.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil {
+			r.logger.Error("Outbox relay failed", zap.Error(err))
+		}
+
+		if err != nil || n < r.cfg.BatchSize {
+			select {
+			case <-time.After(r.cfg.PollInterval):
+			case <-ctx.Done():
+				return nil
+			}
+		} else if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// RelayOnce publishes one batch of pending records and returns how many were sent.
+// Records are published in insertion order and the batch stops at the first
+// failure, which is retried next poll. Per-key order holds because the store
+// lets only one relay process the outbox at a time; other relays stand by.
+//
+// Records that can never be published, such as unrouted event types or
+// unreadable payloads, are marked failed so they do not block the records behind them, and are
+// returned as an error.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	var failures []error
+	n, err := r.store.ProcessPending(ctx, r.cfg.BatchSize, func(ctx context.Context, recs []Record) Outcome {
+		failures = nil
+		outcome := Outcome{Sent: make([]int64, 0, len(recs))}
+		for _, rec := range recs {
+			err := r.publish(ctx, rec)
+			if errors.Is(err, events.ErrUnrouted) || errors.Is(err, ErrInvalidRecord) {
+				r.logger.Error("Outbox record cannot be relayed",
+					zap.Int64("id", rec.ID),
+					zap.String("event_id", rec.EventID),
+					zap.String("event_type", string(rec.EventType)),
+					zap.Error(err),
+				)
+				if outcome.Failed == nil {
+					outcome.Failed = make(map[int64]error)
+				}
+				outcome.Failed[rec.ID] = err
+				failures = append(failures, fmt.Errorf("outbox record %d: %w", rec.ID, err))
+				continue
+			}
+			if err != nil {
+				r.logger.Warn("Failed to relay outbox record",
+					zap.Int64("id", rec.ID),
+					zap.String("event_id", rec.EventID),
+					zap.String("event_type", string(rec.EventType)),
+					zap.Error(err),
+				)
+				break
+			}
+			outcome.Sent = append(outcome.Sent, rec.ID)
+		}
+		return outcome
+	})
+	if err != nil {
+		return n, err
+	}
+	if len(failures) > 0 {
+		return n, fmt.Errorf("failed to relay %d outbox records permanently: %w", len(failures), errors.Join(failures...))
+	}
+	return n, nil
+}
+
+// publish sends rec to every topic its event type is routed to, with the
+// standard event headers of kafka.EventHeaders. If a later topic fails, the
+// record is retried and earlier topics receive it again, so consumers of
+// fanned-out events must deduplicate by event ID.
+func (r *Relay) publish(ctx context.Context, rec Record) error {
+	topics, err := r.cfg.Routes.Topics(rec.EventType, r.cfg.Topics)
+	if err != nil {
+		return err
+	}
+
+	var base events.BaseEvent
+	if err := json.Unmarshal(rec.Payload, &base); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+	}
+
+	for _, topic := range topics {
+		err := r.publisher.PublishMessage(ctx, &sarama.ProducerMessage{
+			Topic:   topic,
+			Key:     sarama.StringEncoder(rec.Key),
+			Value:   sarama.ByteEncoder(rec.Payload),
+			Headers: kafka.EventHeaders(&base),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to publish to %s: %w", topic, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/banking/shared/events"
+)
+
+// tableNameRegex restricts table names interpolated into SQL statements
+var tableNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// SQLStore is a PostgreSQL outbox table. Only one relay processes the table
+// at a time: ProcessPending takes a transaction-scoped advisory lock keyed by
+// the table name, and relays that do not get it return without work. Running
+// several relays is safe for failover, but they do not share the load.
+type SQLStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLStore creates a store using the given table (see CreateTable)
+func NewSQLStore(db *sql.DB, table string) (*SQLStore, error) {
+	if !tableNameRegex.MatchString(table) {
+		return nil, fmt.Errorf("invalid outbox table name %q", table)
+	}
+	return &SQLStore{db: db, table: table}, nil
+}
+
+// CreateTable creates the outbox table and its pending index if they do not exist
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		id BIGSERIAL PRIMARY KEY,
+		event_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		message_key TEXT NOT NULL,
+		payload BYTEA NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		sent_at TIMESTAMPTZ NULL,
+		failed_at TIMESTAMPTZ NULL,
+		last_error TEXT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	// Tables created before records could fail
+	if _, err := s.db.ExecContext(ctx, `ALTER TABLE `+s.table+`
+		ADD COLUMN IF NOT EXISTS failed_at TIMESTAMPTZ NULL,
+		ADD COLUMN IF NOT EXISTS last_error TEXT NULL`); err != nil {
+		return fmt.Errorf("failed to migrate outbox table: %w", err)
+	}
+	return nil
+}
+
+// Insert adds a record inside the caller's transaction
+func (s *SQLStore) Insert(ctx context.Context, tx *sql.Tx, rec Record) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO `+s.table+` (event_id, event_type, message_key, payload, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		rec.EventID, string(rec.EventType), rec.Key, rec.Payload, rec.CreatedAt,
+	)
+	return err
+}
+
+// ProcessPending locks pending records, hands them to fn and marks them sent or
+// failed. Failed records keep the error in last_error; clearing failed_at
+// queues them again. The transaction, and with it the row and advisory locks,
+// stays open while fn publishes, so BatchSize bounds how long the locks are held.
+func (s *SQLStore) ProcessPending(ctx context.Context, limit int, fn func(ctx context.Context, recs []Record) Outcome) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin outbox transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var active bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, s.table).Scan(&active); err != nil {
+		return 0, fmt.Errorf("failed to acquire outbox relay lock: %w", err)
+	}
+	if !active {
+		// Another relay is processing the table
+		return 0, nil
+	}
+
+	recs, err := s.lockPending(ctx, tx, limit)
+	if err != nil {
+		return 0, err
+	}
+	if len(recs) == 0 {
+		return 0, nil
+	}
+
+	outcome := fn(ctx, recs)
+	now := time.Now().UTC()
+	for _, id := range outcome.Sent {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE `+s.table+` SET sent_at = $1 WHERE id = $2`, now, id,
+		); err != nil {
+			return 0, fmt.Errorf("failed to mark outbox record %d sent: %w", id, err)
+		}
+	}
+	for id, cause := range outcome.Failed {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE `+s.table+` SET failed_at = $1, last_error = $2 WHERE id = $3`, now, cause.Error(), id,
+		); err != nil {
+			return 0, fmt.Errorf("failed to mark outbox record %d failed: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox transaction: %w", err)
+	}
+	return len(outcome.Sent), nil
+}
+
+func (s *SQLStore) lockPending(ctx context.Context, tx *sql.Tx, limit int) ([]Record, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, event_id, event_type, message_key, payload, created_at FROM `+s.table+`
+		WHERE sent_at IS NULL AND failed_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE`, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending outbox records: %w", err)
+	}
+	defer rows.Close()
+
+	var recs []Record
+	for rows.Next() {
+		var (
+			rec       Record
+			eventType string
+		)
+		if err := rows.Scan(&rec.ID, &rec.EventID, &eventType, &rec.Key, &rec.Payload, &rec.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox record: %w", err)
+		}
+		rec.EventType = events.EventType(eventType)
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// MemoryStore is an in-memory Store for tests. Insert ignores the transaction.
+type MemoryStore struct {
+	mu         sync.Mutex
+	nextID     int64
+	records    []*memoryRecord
+	processing bool
+}
+
+type memoryRecord struct {
+	Record
+	sent   bool
+	failed bool
+}
+
+// NewMemoryStore creates an empty in-memory outbox
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Insert appends a record, assigning it the next ID
+func (s *MemoryStore) Insert(_ context.Context, _ *sql.Tx, rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	rec.ID = s.nextID
+	s.records = append(s.records, &memoryRecord{Record: rec})
+	return nil
+}
+
+// ProcessPending claims pending records, hands them to fn and marks them sent or failed.
+// Like SQLStore, it returns without work while another call is processing.
+func (s *MemoryStore) ProcessPending(ctx context.Context, limit int, fn func(ctx context.Context, recs []Record) Outcome) (int, error) {
+	s.mu.Lock()
+	if s.processing {
+		s.mu.Unlock()
+		return 0, nil
+	}
+	var claimed []*memoryRecord
+	for _, r := range s.records {
+		if len(claimed) == limit {
+			break
+		}
+		if !r.sent && !r.failed {
+			claimed = append(claimed, r)
+		}
+	}
+	if len(claimed) == 0 {
+		s.mu.Unlock()
+		return 0, nil
+	}
+	s.processing = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.processing = false
+		s.mu.Unlock()
+	}()
+
+	recs := make([]Record, len(claimed))
+	for i, r := range claimed {
+		recs[i] = r.Record
+	}
+	outcome := fn(ctx, recs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sentIDs := make(map[int64]bool, len(outcome.Sent))
+	for _, id := range outcome.Sent {
+		sentIDs[id] = true
+	}
+	for _, r := range claimed {
+		if sentIDs[r.ID] {
+			r.sent = true
+		} else if _, ok := outcome.Failed[r.ID]; ok {
+			r.failed = true
+		}
+	}
+	return len(outcome.Sent), nil
+}
+
+// Pending returns the records neither sent nor failed
+func (s *MemoryStore) Pending() []Record {
+	return s.filter(func(r *memoryRecord) bool { return !r.sent && !r.failed })
+}
+
+// Failed returns the records set aside as failed
+func (s *MemoryStore) Failed() []Record {
+	return s.filter(func(r *memoryRecord) bool { return r.failed })
+}
+
+func (s *MemoryStore) filter(keep func(r *memoryRecord) bool) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []Record
+	for _, r := range s.records {
+		if keep(r) {
+			out = append(out, r.Record)
+		}
+	}
+	return out
+}